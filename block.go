@@ -31,11 +31,9 @@
 package hpcloud
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
 	"strings"
 )
 
@@ -47,6 +45,7 @@ import (
 // endpoints which return containers of info about the volumes you may
 // have.
 type Volume struct {
+	ID               int64             `json:"id"`
 	Status           string            `json:"status"`
 	CreatedAt        string            `json:"createdAt"`
 	Size             int64             `json:"size"`
@@ -119,10 +118,30 @@ func (a Access) ListSnapshots() ([]Volume, error) {
 	return vs.V, nil
 }
 
+// GetVolume returns the volume with the volume_id.
+func (a Access) GetVolume(volume_id string) (*Volume, error) {
+	resp, err := a.baseRequest(
+		fmt.Sprintf("%s%s/os-volumes/%s", COMPUTE_URL, a.TenantID, volume_id),
+		"GET", nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		V Volume `json:"volume"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(resp, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.V, nil
+}
+
 // NewVolume takes a volume instance and will create that in the
 // cloud. This function will return *before* the instance is
 // created. In order to know when the instance has been created you
-// will need to check the status using the provided methods.
+// can wait on it with WaitForVolume.
 func (a Access) NewVolume(v *Volume) error {
 	b, err := v.MarshalJSON()
 	if err != nil {
@@ -135,7 +154,10 @@ func (a Access) NewVolume(v *Volume) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(resp, v)
+	type Output struct {
+		V *Volume `json:"volume"`
+	}
+	return json.Unmarshal(resp, &Output{v})
 }
 
 // DetachVolume will remove a volume from whatever server it is
@@ -156,46 +178,29 @@ func (a Access) DetachVolume(at Attachment) error {
 // We override MarshalJSON because we want to provide additional
 // marshaling logic when creating new compute nodes. This is because
 // the zero values of Volumes are not valid parameters for the compute
-// API.
+// API, so only the fields which are set are sent.
 func (v Volume) MarshalJSON() ([]byte, error) {
 	if v.Size <= 0 {
 		return nil, errors.New("Size cannot be <= 0")
 	}
-	b := bytes.NewBufferString(
-		fmt.Sprintf(`{"volume":{"size":%d`, v.Size),
-	)
-
-	val := reflect.ValueOf(&v).Elem()
-	var i64 int64
-	var str string
-
-	// Iterate through via reflect on the remaining fields. These
-	// fields require no special treatment and therefore can be simply
-	// interpolated into the request.
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		T := reflect.TypeOf(v).Field(i)
-		if field.Type() == reflect.ValueOf(i64).Type() && field.Int() != i64 {
-			b.WriteString(
-				fmt.Sprintf(`,"%s": %d`, T.Tag.Get("json"), field.Int()),
-			)
-		}
-		if field.Type() == reflect.ValueOf(str).Type() && field.String() != str {
-			b.WriteString(
-				fmt.Sprintf(`,"%s": "%s"`, T.Tag.Get("json"), field.String()),
-			)
-		}
-	}
-	// We ignore the metadata completely if there are no additional
-	// fields.
-	if len(v.Metadata) > 0 {
-		b.WriteString(`,"metadata":{`)
-		metadata := make([]string, 0, len(v.Metadata))
-		for k, v := range v.Metadata {
-			metadata = append(metadata, fmt.Sprintf(`"%s":"%s"`, k, v))
-		}
-		b.WriteString(strings.Join(metadata, ",") + "}")
+	type volume struct {
+		Size             int64             `json:"size"`
+		DisplayName      string            `json:"display_name,omitempty"`
+		DisplayDesc      string            `json:"display_description,omitempty"`
+		SnapshotID       int64             `json:"snapshot_id,omitempty"`
+		ImageRef         int64             `json:"imageRef,omitempty"`
+		AvailabilityZone string            `json:"availability_zone,omitempty"`
+		VolumeType       string            `json:"volume_type,omitempty"`
+		Metadata         map[string]string `json:"metadata,omitempty"`
 	}
-	b.WriteString("}}")
-	return b.Bytes(), nil
+	return json.Marshal(map[string]volume{"volume": {
+		Size:             v.Size,
+		DisplayName:      v.DisplayName,
+		DisplayDesc:      v.DisplayDesc,
+		SnapshotID:       v.SnapshotID,
+		ImageRef:         v.ImageRef,
+		AvailabilityZone: v.AvailabilityZone,
+		VolumeType:       v.VolumeType,
+		Metadata:         v.Metadata,
+	}})
 }
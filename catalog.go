@@ -0,0 +1,144 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// findRef finds the one entry of the catalog which ref refers to. ref
+// is tried as an ID, then as an exact name and finally as a regular
+// expression matching the names, which must match only one entry.
+func findRef(kind, ref string, catalog []IDLink) (IDLink, error) {
+	for _, entry := range catalog {
+		if entry.ID == ref {
+			return entry, nil
+		}
+	}
+	for _, entry := range catalog {
+		if entry.Name == ref {
+			return entry, nil
+		}
+	}
+	re, err := regexp.Compile(ref)
+	if err != nil {
+		return IDLink{}, errors.New(fmt.Sprintf("No %s named %s", kind, ref))
+	}
+	matches := []IDLink{}
+	for _, entry := range catalog {
+		if re.MatchString(entry.Name) {
+			matches = append(matches, entry)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return IDLink{}, errors.New(fmt.Sprintf("No %s matches %s", kind, ref))
+	case 1:
+		return matches[0], nil
+	}
+	names := make([]string, len(matches))
+	for i, entry := range matches {
+		names[i] = entry.Name
+	}
+	return IDLink{}, errors.New(fmt.Sprintf(
+		"%s matches %d %ss: %s", ref, len(matches), kind, strings.Join(names, ", "),
+	))
+}
+
+func (a Access) flavorCatalog() ([]IDLink, error) {
+	fl, err := a.ListFlavors()
+	if err != nil {
+		return nil, err
+	}
+	catalog := make([]IDLink, len(fl.F))
+	for i, f := range fl.F {
+		catalog[i] = IDLink{Name: f.Name, ID: string(f.ID), Links: f.Links}
+	}
+	return catalog, nil
+}
+
+// FindFlavor looks up a flavor in the compute catalog by its ID, its
+// name or a regular expression matching only its name.
+func (a Access) FindFlavor(ref string) (Flavor, error) {
+	catalog, err := a.flavorCatalog()
+	if err != nil {
+		return "", err
+	}
+	f, err := findRef("flavor", ref, catalog)
+	if err != nil {
+		return "", err
+	}
+	return Flavor(f.ID), nil
+}
+
+// FindImage looks up an image in the compute catalog by its ID, its
+// name or a regular expression matching only its name.
+func (a Access) FindImage(ref string) (ServerImage, error) {
+	im, err := a.ListImages()
+	if err != nil {
+		return "", err
+	}
+	i, err := findRef("image", ref, im.I)
+	if err != nil {
+		return "", err
+	}
+	return ServerImage(i.ID), nil
+}
+
+// ValidateServer checks the flavor and image of the server exist in
+// the compute catalog, so that a server can be checked before it is
+// created. CreateServer does not call it, so the check is opt-in.
+func (a Access) ValidateServer(s Server) error {
+	flavors, err := a.ListFlavors()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, f := range flavors.F {
+		found = found || f.ID == s.FlavorRef
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("No flavor with the ID %s", s.FlavorRef))
+	}
+	images, err := a.ListImages()
+	if err != nil {
+		return err
+	}
+	for _, i := range images.I {
+		if i.ID == string(s.ImageRef) {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("No image with the ID %s", s.ImageRef))
+}
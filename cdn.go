@@ -34,131 +34,284 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 /*
   The CDN endpoints are the most "ReSTful" of all the HPCloud endpoints,
   we use the same endpoint for each container and change the verb and
-  status code we use with each one.
+  headers we use with each one.
+
+  The container, or "container/object" for an object, is escaped here.
 */
-func (a Access) baseCDNRequest(method, container string, StatusCode int) error {
-	client := &http.Client{}
-	path := fmt.Sprintf("%s%s/%s", CDN_URL, a.TenantID, container)
-	req, err := http.NewRequest(method, path, nil)
-	if err != nil {
-		return err
+func (a Access) baseCDNRequest(method, container string, header http.Header) (http.Header, error) {
+	_, h, err := a.baseRequestWithHeaders(
+		fmt.Sprintf("%s%s/%s", CDN_URL, a.TenantID, escapeObjectName(container)),
+		method, nil, header,
+	)
+	return h, err
+}
+
+/*
+  CDNSettings are the settings of a CDN enabled container.
+
+  Only the fields which are set are sent, so an update changes just
+  those and leaves the rest of the container's settings as they are.
+  A container created without them gets the CDN's defaults.
+*/
+type CDNSettings struct {
+	Enabled      *bool
+	TTL          *int64
+	LogRetention *bool
+}
+
+/*
+  header gives the headers which the CDN takes the settings in.
+*/
+func (s CDNSettings) header() http.Header {
+	h := http.Header{}
+	if s.Enabled != nil {
+		h.Set("X-Cdn-Enabled", cdnBool(*s.Enabled))
 	}
-	req.Header.Add("X-Auth-Token", a.AuthToken())
-	req.Header.Add("Accept", "application/json")
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if s.LogRetention != nil {
+		h.Set("X-Log-Retention", cdnBool(*s.LogRetention))
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == StatusCode {
-		return nil
+	if s.TTL != nil {
+		h.Set("X-Ttl", strconv.FormatInt(*s.TTL, 10))
 	}
-	return errors.New(fmt.Sprintf("Non-%d status code: %d", StatusCode, resp.StatusCode))
+	return h
+}
 
+func cdnBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
 }
 
 /*
   Activates a container for the CDN network.
 */
 func (a Access) ActivateCDNContainer(container string) error {
-	return a.baseCDNRequest("PUT", container, http.StatusCreated)
+	_, err := a.baseCDNRequest("PUT", container, nil)
+	return err
 }
 
 /*
-  Lists available containers.
+  CreateCDNContainer activates a container for the CDN network with the
+  settings given.
+*/
+func (a Access) CreateCDNContainer(container string, s CDNSettings) error {
+	_, err := a.baseCDNRequest("PUT", container, s.header())
+	return err
+}
 
-  When enabled_only == true you will only receive the containers which
-  are enabled and the disabled containers will be ignored.
+/*
+  UpdateCDNContainer changes the settings of a container which is
+  already on the CDN network.
 */
-func (a Access) ListCDNEnabledContainers(enabled_only bool) (*CDNContainers, error) {
-	client := &http.Client{}
-	qstring := "?format=json"
-	if enabled_only {
-		qstring = qstring + "&enabled_only=true"
-	}
-	path := fmt.Sprintf("%s%s%s", CDN_URL, a.TenantID, qstring)
-	req, err := http.NewRequest("GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("X-Auth-Token", a.AuthToken())
-	req.Header.Add("Accept", "application/json")
-	resp, err := client.Do(req)
+func (a Access) UpdateCDNContainer(container string, s CDNSettings) error {
+	_, err := a.baseCDNRequest("POST", container, s.header())
+	return err
+}
+
+/*
+  GetCDNContainer returns the CDN settings and URIs of a single
+  container.
+*/
+func (a Access) GetCDNContainer(container string) (*CDNContainer, error) {
+	h, err := a.baseCDNRequest("HEAD", container, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	c := &CDNContainer{
+		Name:         container,
+		CDNEnabled:   strings.EqualFold(h.Get("X-Cdn-Enabled"), "True"),
+		CDNUri:       h.Get("X-Cdn-Uri"),
+		SSLCDNUri:    h.Get("X-Cdn-Ssl-Uri"),
+		StreamingUri: h.Get("X-Cdn-Streaming-Uri"),
+		LogRetention: strings.EqualFold(h.Get("X-Log-Retention"), "True"),
 	}
-	if resp.StatusCode == http.StatusOK {
-		c := &CDNContainers{}
-		err = json.Unmarshal(b, c)
+	if ttl := h.Get("X-Ttl"); ttl != "" {
+		c.TTL, err = strconv.ParseInt(ttl, 10, 64)
 		if err != nil {
 			return nil, err
 		}
-		return c, nil
 	}
-	return nil, errors.New(fmt.Sprintf("Non-200 status code: %d", resp.StatusCode))
+	return c, nil
 }
 
 /*
-  Updates the metadata associated with a container.
+  CDNListOptions select the containers returned from the CDN listing
+  calls.
 
-  data will be the extra headers sent with the request, which ultimately
-  end up being the metadata.
+  Marker, EndMarker and Limit are passed on to the CDN, which only
+  returns the containers named after Marker and before EndMarker, at
+  most Limit at a time. Containers which do not begin with Prefix are
+  filtered out as they are listed.
 */
-func (a Access) UpdateCDNEnabledContainerMetadata(container string, data map[string]string) error {
-	client := &http.Client{}
-	path := fmt.Sprintf("%s%s/%s", CDN_URL, a.TenantID, container)
-	req, err := http.NewRequest("POST", path, nil)
+type CDNListOptions struct {
+	Marker      string
+	EndMarker   string
+	Limit       int
+	EnabledOnly bool
+	Prefix      string
+}
+
+func (o CDNListOptions) query() string {
+	q := url.Values{}
+	q.Set("format", "json")
+	if o.EnabledOnly {
+		q.Set("enabled_only", "true")
+	}
+	if o.Marker != "" {
+		q.Set("marker", o.Marker)
+	}
+	if o.EndMarker != "" {
+		q.Set("end_marker", o.EndMarker)
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return "?" + q.Encode()
+}
+
+/*
+  ListCDNContainersPage lists a single page of the CDN enabled
+  containers. The name of the last container returned, including one
+  which was filtered out by the Prefix, is the Marker for the next
+  page, which is returned as well.
+
+  The returned marker is empty once the listing is done, which is when
+  the CDN returns no containers or fewer than Limit. With a Prefix a
+  page can be empty before then, so loop on the marker rather than on
+  the length of the page.
+*/
+func (a Access) ListCDNContainersPage(opts CDNListOptions) (CDNContainers, string, error) {
+	b, err := a.baseRequest(
+		fmt.Sprintf("%s%s%s", CDN_URL, a.TenantID, opts.query()),
+		"GET", nil,
+	)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-	req.Header.Add("X-Auth-Token", a.AuthToken())
-	for key, value := range data {
-		req.Header.Add(key, value)
+	page := CDNContainers{}
+	if len(b) == 0 {
+		return page, "", nil
 	}
-	resp, err := client.Do(req)
+	err = json.Unmarshal(b, &page)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusAccepted {
-		return nil
+	if len(page) == 0 {
+		return page, "", nil
 	}
-	return errors.New(fmt.Sprintf("Non-202 status code: %d", resp.StatusCode))
+	next := page[len(page)-1].Name
+	if opts.Limit > 0 && len(page) < opts.Limit {
+		next = ""
+	}
+	matching := CDNContainers{}
+	for _, c := range page {
+		if strings.HasPrefix(c.Name, opts.Prefix) {
+			matching = append(matching, c)
+		}
+	}
+	return matching, next, nil
+}
 
+/*
+  EachCDNContainer calls f with every CDN enabled container, fetching
+  them a page at a time. Iteration stops at the first error f returns,
+  which is returned.
+*/
+func (a Access) EachCDNContainer(opts CDNListOptions, f func(CDNContainer) error) error {
+	for {
+		page, next, err := a.ListCDNContainersPage(opts)
+		if err != nil {
+			return err
+		}
+		for _, c := range page {
+			if err := f(c); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		/*
+		  The listing is sorted so once we're past the prefix there is
+		  nothing left to match.
+		*/
+		if opts.Prefix != "" && next > opts.Prefix && !strings.HasPrefix(next, opts.Prefix) {
+			return nil
+		}
+		opts.Marker = next
+	}
 }
 
 /*
-  Will return the metadata associated with a single container.
+  ListAllCDNContainers returns every CDN enabled container matching
+  the options.
 */
-func (a Access) RetrieveCDNEnabledContainerMetadata(container string) (*http.Header, error) {
-	client := &http.Client{}
-	path := fmt.Sprintf("%s%s/%s", CDN_URL, a.TenantID, container)
-	req, err := http.NewRequest("HEAD", path, nil)
+func (a Access) ListAllCDNContainers(opts CDNListOptions) (CDNContainers, error) {
+	all := CDNContainers{}
+	err := a.EachCDNContainer(opts, func(c CDNContainer) error {
+		all = append(all, c)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("X-Auth-Token", a.AuthToken())
-	resp, err := client.Do(req)
+	return all, nil
+}
+
+/*
+  Lists available containers.
+
+  When enabled_only == true you will only receive the containers which
+  are enabled and the disabled containers will be ignored.
+
+  Only the first page of containers is returned, ListAllCDNContainers
+  returns all of them.
+*/
+func (a Access) ListCDNEnabledContainers(enabled_only bool) (*CDNContainers, error) {
+	c, _, err := a.ListCDNContainersPage(CDNListOptions{EnabledOnly: enabled_only})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNoContent {
-		return &resp.Header, nil
+	return &c, nil
+}
+
+/*
+  Updates the metadata associated with a container.
+
+  data will be the extra headers sent with the request, which ultimately
+  end up being the metadata. UpdateCDNContainer should be preferred for
+  the settings the CDN knows about.
+*/
+func (a Access) UpdateCDNEnabledContainerMetadata(container string, data map[string]string) error {
+	h := http.Header{}
+	for key, value := range data {
+		h.Add(key, value)
 	}
-	return nil, errors.New(fmt.Sprintf("Non-205 status code: %d", resp.StatusCode))
+	_, err := a.baseCDNRequest("POST", container, h)
+	return err
+}
+
+/*
+  Will return the metadata associated with a single container.
+
+  GetCDNContainer should be preferred, which parses the metadata.
+*/
+func (a Access) RetrieveCDNEnabledContainerMetadata(container string) (*http.Header, error) {
+	h, err := a.baseCDNRequest("HEAD", container, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
 }
 
 /*
@@ -186,7 +339,48 @@ func (a Access) EnableCDNEnabledContainer(container string) error {
   the container from the objectstore.
 */
 func (a Access) DeleteCDNEnabledContainer(container string) error {
-	return a.baseCDNRequest("DELETE", container, http.StatusNoContent)
+	_, err := a.baseCDNRequest("DELETE", container, nil)
+	return err
+}
+
+/*
+  PurgeCDNObject removes an object from the CDN's caches, so that the
+  next request for it is served from the object store. When email is
+  not empty a notification is sent to it once the purge is done.
+*/
+func (a Access) PurgeCDNObject(container, object, email string) error {
+	h := http.Header{}
+	if email != "" {
+		h.Set("X-Purge-Email", email)
+	}
+	_, err := a.baseCDNRequest(
+		"DELETE", fmt.Sprintf("%s/%s", container, object), h,
+	)
+	return err
+}
+
+/*
+  PurgeCDNPrefix purges every object in the container whose name
+  starts with prefix. Every object is tried, the names of the ones
+  which could not be purged are returned in the error.
+*/
+func (a Access) PurgeCDNPrefix(container, prefix, email string) error {
+	objects, err := a.ListAllObjects(container, prefix, "")
+	if err != nil {
+		return err
+	}
+	failed := []string{}
+	for _, object := range objects {
+		if err := a.PurgeCDNObject(container, object.Name, email); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", object.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf(
+			"Failed to purge %d objects: %s", len(failed), strings.Join(failed, ", "),
+		))
+	}
+	return nil
 }
 
 type CDNContainers []CDNContainer
@@ -196,5 +390,37 @@ type CDNContainer struct {
 	TTL          int64  `json:"ttl"`
 	CDNUri       string `json:"x-cdn-uri"`
 	SSLCDNUri    string `json:"x-cdn-ssl-uri"`
+	StreamingUri string `json:"x-cdn-streaming-uri"`
 	LogRetention bool   `json:"log_retention"`
 }
+
+/*
+  ObjectURL returns the public HTTP URL of an object served from the
+  container through the CDN.
+*/
+func (c CDNContainer) ObjectURL(object string) (string, error) {
+	return cdnObjectURL(c.CDNUri, "HTTP", object)
+}
+
+/*
+  ObjectSSLURL returns the public HTTPS URL of an object served from
+  the container through the CDN.
+*/
+func (c CDNContainer) ObjectSSLURL(object string) (string, error) {
+	return cdnObjectURL(c.SSLCDNUri, "HTTPS", object)
+}
+
+/*
+  ObjectStreamingURL returns the URL an object in the container is
+  streamed from through the CDN.
+*/
+func (c CDNContainer) ObjectStreamingURL(object string) (string, error) {
+	return cdnObjectURL(c.StreamingUri, "streaming", object)
+}
+
+func cdnObjectURL(base, kind, object string) (string, error) {
+	if base == "" {
+		return "", errors.New(fmt.Sprintf("The container has no %s CDN URI.", kind))
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(base, "/"), escapeObjectName(object)), nil
+}
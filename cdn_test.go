@@ -0,0 +1,258 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCreateCDNContainer(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" || req.URL.Path != "/cdn//images" {
+			t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+		}
+		expected := map[string]string{
+			"X-Auth-Token":    "faketoken",
+			"X-Cdn-Enabled":   "True",
+			"X-Ttl":           "3600",
+			"X-Log-Retention": "False",
+		}
+		for key, value := range expected {
+			if req.Header.Get(key) != value {
+				t.Errorf("%s: expected %q, got %q", key, value, req.Header.Get(key))
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	enabled, ttl, logs := true, int64(3600), false
+	err := test_account.CreateCDNContainer("images", CDNSettings{
+		Enabled: &enabled, TTL: &ttl, LogRetention: &logs,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateCDNContainerTTL(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.URL.Path != "/cdn//images" {
+			t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+		}
+		if req.Header.Get("X-Ttl") != "3600" {
+			t.Errorf("Unexpected X-Ttl: %q", req.Header.Get("X-Ttl"))
+		}
+		for _, key := range []string{"X-Cdn-Enabled", "X-Log-Retention"} {
+			if _, ok := req.Header[key]; ok {
+				t.Errorf("%s was sent with a TTL only update.", key)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ttl := int64(3600)
+	if err := test_account.UpdateCDNContainer("images", CDNSettings{TTL: &ttl}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetCDNContainer(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Cdn-Enabled", "True")
+		w.Header().Set("X-Ttl", "86400")
+		w.Header().Set("X-Log-Retention", "True")
+		w.Header().Set("X-Cdn-Uri", "http://h.example.com")
+		w.Header().Set("X-Cdn-Ssl-Uri", "https://s.example.com")
+		w.Header().Set("X-Cdn-Streaming-Uri", "http://st.example.com")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c, err := test_account.GetCDNContainer("images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CDNContainer{
+		Name:         "images",
+		CDNEnabled:   true,
+		TTL:          86400,
+		CDNUri:       "http://h.example.com",
+		SSLCDNUri:    "https://s.example.com",
+		StreamingUri: "http://st.example.com",
+		LogRetention: true,
+	}
+	if *c != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *c)
+	}
+}
+
+func TestGetCDNContainerNotFound(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	_, err := test_account.GetCDNContainer("images")
+	if se, ok := err.(StatusError); !ok || se.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 StatusError, got %v", err)
+	}
+}
+
+func TestCDNObjectURLs(t *testing.T) {
+	c := CDNContainer{CDNUri: "http://h.example.com/", SSLCDNUri: "https://s.example.com"}
+	u, err := c.ObjectURL("css/site main.css")
+	if err != nil || u != "http://h.example.com/css/site%20main.css" {
+		t.Errorf("Unexpected HTTP URL: %q, %v", u, err)
+	}
+	u, err = c.ObjectSSLURL("index.html")
+	if err != nil || u != "https://s.example.com/index.html" {
+		t.Errorf("Unexpected HTTPS URL: %q, %v", u, err)
+	}
+	if _, err = c.ObjectStreamingURL("video.mp4"); err == nil {
+		t.Error("Expected an error for a container without a streaming URI.")
+	}
+}
+
+func TestPurgeCDNObject(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "DELETE" || req.URL.EscapedPath() != "/cdn//my%20images%3F/logo%20big.png" {
+			t.Errorf("Unexpected request: %s %s", req.Method, req.URL.EscapedPath())
+		}
+		if req.Header.Get("X-Purge-Email") != "ops@example.com" {
+			t.Errorf("Unexpected purge email: %q", req.Header.Get("X-Purge-Email"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	err := test_account.PurgeCDNObject("my images?", "logo big.png", "ops@example.com")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+const cdnLogSample = `10.0.0.1 - - [21/Jun/2013:14:05:00 +0000] "GET /css/site.css?v=2 HTTP/1.1" 200 1200 "-" "curl/7.30"
+10.0.0.2 - - [21/Jun/2013:14:06:00 +0000] "GET /css/site.css HTTP/1.1" 304 - "http://example.com/" "Mozilla/5.0"
+
+10.0.0.3 - - [21/Jun/2013:14:07:00 +0000] "GET /index.html HTTP/1.1" 200 512 "-" "Mozilla/5.0"
+`
+
+func TestCDNLogReport(t *testing.T) {
+	objectStoreStandIn(t, nil)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(cdnLogSample))
+	w.Close()
+	logs := map[string][]byte{
+		"images/2013/06/21/14/a.log.gz": gz.Bytes(),
+		"images/2013/06/21/16/b.log":    []byte("not a log line\n"),
+		"other/2013/06/21/14/c.log":     []byte("not a log line\n"),
+	}
+	for name, contents := range logs {
+		err := test_account.ObjectStoreUploadBytes(contents, CDNAccessLogs, &UploadOptions{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := time.Date(2013, 6, 21, 14, 0, 0, 0, time.UTC)
+	report, err := test_account.CDNLogReport("images", from, from.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	css := report["css/site.css"]
+	if len(report) != 2 || css == nil || css.Hits != 2 || css.Bytes != 1200 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if css.Status[200] != 1 || css.Status[304] != 1 {
+		t.Errorf("Unexpected status codes: %v", css.Status)
+	}
+	if index := report["index.html"]; index == nil || index.Hits != 1 || index.Bytes != 512 {
+		t.Errorf("Unexpected index.html stats: %+v", index)
+	}
+}
+
+func TestListAllCDNContainers(t *testing.T) {
+	names := []string{"archive", "assets", "backups-1", "backups-2", "backups-3", "video"}
+	requests := 0
+	limit := "2"
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		q := req.URL.Query()
+		if q.Get("enabled_only") != "true" || q.Get("limit") != limit {
+			t.Errorf("Unexpected query: %s", req.URL.RawQuery)
+		}
+		n, _ := strconv.Atoi(q.Get("limit"))
+		start := sort.SearchStrings(names, q.Get("marker")+"\x00")
+		page := []CDNContainer{}
+		for _, name := range names[start:] {
+			if len(page) == n {
+				break
+			}
+			page = append(page, CDNContainer{Name: name, CDNEnabled: true})
+		}
+		/* The CDN ends a listing with an empty 204. */
+		if len(page) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	/* The first page is filtered out entirely by the prefix. */
+	all, err := test_account.ListAllCDNContainers(CDNListOptions{
+		Limit: 2, EnabledOnly: true, Prefix: "backups-",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Name != "backups-1" || all[2].Name != "backups-3" {
+		t.Errorf("Unexpected containers: %+v", all)
+	}
+	if requests != 3 {
+		t.Errorf("Expected the listing to stop past the prefix, made %d requests", requests)
+	}
+
+	requests, limit = 0, "3"
+	all, err = test_account.ListAllCDNContainers(CDNListOptions{Limit: 3, EnabledOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(names) || requests != 3 {
+		t.Errorf("Expected %d containers from 3 requests, got %d from %d", len(names), len(all), requests)
+	}
+
+	requests, limit = 0, "4"
+	all, err = test_account.ListAllCDNContainers(CDNListOptions{Limit: 4, EnabledOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(names) || requests != 2 {
+		t.Errorf("Expected a short page to end the listing, made %d requests", requests)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CDNAccessLogs is the object store container the CDN writes the
+// access logs of containers with LogRetention enabled into.
+const CDNAccessLogs = ".CDN_ACCESS_LOGS"
+
+// cdnLogHourLayout is the layout of the hour, within the log object's
+// name, the log object covers:
+//
+//	<container>/2013/06/21/14/<id>.log.gz
+const cdnLogHourLayout = "2006/01/02/15"
+
+// cdnLogTimeLayout is the layout of the timestamps of the log lines.
+const cdnLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// cdnLogLine matches a line in the combined log format.
+var cdnLogLine = regexp.MustCompile(
+	`^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) (\S+) ?([^"]*)" (\d{3}) (\d+|-)(?: "([^"]*)" "([^"]*)")?`,
+)
+
+// CDNLogEntry is a single request from a CDN access log.
+type CDNLogEntry struct {
+	RemoteAddr string
+	Time       time.Time
+	Method     string
+	// Object is the name of the object requested, without the query
+	// string of the request.
+	Object    string
+	Protocol  string
+	Status    int
+	Bytes     int64
+	Referer   string
+	UserAgent string
+}
+
+// CDNObjectStats are the aggregated requests for a single object.
+type CDNObjectStats struct {
+	Hits   int64
+	Bytes  int64
+	Status map[int]int64
+}
+
+// ListCDNLogs lists the access log objects of the container which cover
+// any of the time between from and to.
+func (a Access) ListCDNLogs(container string, from, to time.Time) (FileList, error) {
+	prefix := container + "/"
+	logs, err := a.ListAllObjects(CDNAccessLogs, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	matching := FileList{}
+	for _, log := range logs {
+		name := strings.TrimPrefix(log.Name, prefix)
+		if len(name) < len(cdnLogHourLayout) {
+			continue
+		}
+		hour, err := time.Parse(cdnLogHourLayout, name[:len(cdnLogHourLayout)])
+		if err != nil {
+			continue
+		}
+		if hour.Before(to) && hour.Add(time.Hour).After(from) {
+			matching = append(matching, log)
+		}
+	}
+	return matching, nil
+}
+
+// ReadCDNLog downloads and parses a single access log object, as
+// returned from ListCDNLogs.
+func (a Access) ReadCDNLog(name string) ([]CDNLogEntry, error) {
+	contents, _, err := a.ObjectStoreDownload(CDNAccessLogs + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = bytes.NewReader(contents)
+	if len(contents) > 1 && contents[0] == 0x1f && contents[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return ParseCDNLog(r)
+}
+
+// CDNLogs returns the entries of every access log of the container
+// which were logged between from and to.
+func (a Access) CDNLogs(container string, from, to time.Time) ([]CDNLogEntry, error) {
+	logs, err := a.ListCDNLogs(container, from, to)
+	if err != nil {
+		return nil, err
+	}
+	entries := []CDNLogEntry{}
+	for _, log := range logs {
+		logged, err := a.ReadCDNLog(log.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range logged {
+			if !entry.Time.Before(from) && entry.Time.Before(to) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// CDNLogReport aggregates the access logs of the container between
+// from and to by object.
+func (a Access) CDNLogReport(container string, from, to time.Time) (map[string]*CDNObjectStats, error) {
+	entries, err := a.CDNLogs(container, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return SummarizeCDNLogs(entries), nil
+}
+
+// ParseCDNLog parses the lines of an access log. Blank lines are
+// skipped, any other line which can't be parsed is an error.
+func ParseCDNLog(r io.Reader) ([]CDNLogEntry, error) {
+	entries := []CDNLogEntry{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry, err := parseCDNLogLine(line)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Line %d: %s", n, err))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func parseCDNLogLine(line string) (CDNLogEntry, error) {
+	m := cdnLogLine.FindStringSubmatch(line)
+	if m == nil {
+		return CDNLogEntry{}, errors.New("Not in the combined log format.")
+	}
+	t, err := time.Parse(cdnLogTimeLayout, m[2])
+	if err != nil {
+		return CDNLogEntry{}, err
+	}
+	status, err := strconv.Atoi(m[6])
+	if err != nil {
+		return CDNLogEntry{}, err
+	}
+	var size int64
+	if m[7] != "-" {
+		size, err = strconv.ParseInt(m[7], 10, 64)
+		if err != nil {
+			return CDNLogEntry{}, err
+		}
+	}
+	object := m[4]
+	if u, err := url.Parse(m[4]); err == nil {
+		object = u.Path
+	}
+	return CDNLogEntry{
+		RemoteAddr: m[1],
+		Time:       t,
+		Method:     m[3],
+		Object:     strings.TrimPrefix(object, "/"),
+		Protocol:   m[5],
+		Status:     status,
+		Bytes:      size,
+		Referer:    m[8],
+		UserAgent:  m[9],
+	}, nil
+}
+
+// SummarizeCDNLogs aggregates the hits, bytes sent and status codes of
+// the entries by object.
+func SummarizeCDNLogs(entries []CDNLogEntry) map[string]*CDNObjectStats {
+	stats := map[string]*CDNObjectStats{}
+	for _, entry := range entries {
+		s, ok := stats[entry.Object]
+		if !ok {
+			s = &CDNObjectStats{Status: map[int]int64{}}
+			stats[entry.Object] = s
+		}
+		s.Hits++
+		s.Bytes += entry.Bytes
+		s.Status[entry.Status]++
+	}
+	return stats
+}
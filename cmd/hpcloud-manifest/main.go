@@ -0,0 +1,120 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Command hpcloud-manifest records the checksums of the objects in a
+// container in a signed manifest, and later verifies the container
+// against it.
+//
+// The password and the manifest signing key are read from the
+// HPCLOUD_PASSWORD and HPCLOUD_MANIFEST_KEY environment variables:
+//
+//	hpcloud-manifest -user me -tenant 12345 -container archive generate > archive.json
+//	hpcloud-manifest -user me -tenant 12345 verify < archive.json
+//
+// verify exits with a non-zero status if any object is missing,
+// altered or was added since the manifest was generated.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/AeroNotix/hpcloud"
+)
+
+func main() {
+	user := flag.String("user", "", "HP Cloud username")
+	tenant := flag.String("tenant", "", "HP Cloud tenant ID")
+	container := flag.String("container", "", "container to generate a manifest for")
+	prefix := flag.String("prefix", "", "only include objects beginning with this prefix")
+	rehash := flag.Bool("rehash", true, "download every object to check its SHA-256 when verifying")
+	objectStore := flag.String("object-store", hpcloud.OBJECT_STORE, "object store endpoint")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] generate|verify\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	password := os.Getenv("HPCLOUD_PASSWORD")
+	key := []byte(os.Getenv("HPCLOUD_MANIFEST_KEY"))
+	if flag.NArg() != 1 || *user == "" || *tenant == "" || password == "" || len(key) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	hpcloud.OBJECT_STORE = *objectStore
+	a, err := hpcloud.Authenticate(*user, password, *tenant)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "generate":
+		if *container == "" {
+			log.Fatal("-container is required to generate a manifest")
+		}
+		m, err := a.GenerateManifest(*container, *prefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := m.Sign(key); err != nil {
+			log.Fatal(err)
+		}
+		if err := hpcloud.WriteManifest(os.Stdout, m); err != nil {
+			log.Fatal(err)
+		}
+	case "verify":
+		m, err := hpcloud.ReadManifest(os.Stdin, key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		report, err := a.VerifyManifest(m, *rehash)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range report.Missing {
+			fmt.Println("missing", name)
+		}
+		for _, name := range report.Altered {
+			fmt.Println("altered", name)
+		}
+		for _, name := range report.Extra {
+			fmt.Println("extra", name)
+		}
+		if !report.OK() {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
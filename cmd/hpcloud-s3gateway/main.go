@@ -0,0 +1,82 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Command hpcloud-s3gateway serves a subset of the S3 API in front of
+// the HP Cloud object store, so that tools which only speak S3 can use
+// it.
+//
+// The password is read from the HPCLOUD_PASSWORD environment variable
+// so that it does not show up in the process list:
+//
+//	HPCLOUD_PASSWORD=... hpcloud-s3gateway -user me -tenant 12345
+//
+// Request signatures are not checked, so only listen on an address
+// which trusted clients can reach.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/AeroNotix/hpcloud"
+	"github.com/AeroNotix/hpcloud/s3gateway"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:9000", "address to serve the S3 API on")
+	user := flag.String("user", "", "HP Cloud username")
+	tenant := flag.String("tenant", "", "HP Cloud tenant ID")
+	objectStore := flag.String("object-store", hpcloud.OBJECT_STORE, "object store endpoint")
+	maxBody := flag.Int64("max-body", s3gateway.DefaultMaxBodySize, "largest request body accepted, in bytes")
+	flag.Parse()
+
+	password := os.Getenv("HPCLOUD_PASSWORD")
+	if *user == "" || *tenant == "" || password == "" {
+		log.Fatal("-user, -tenant and HPCLOUD_PASSWORD are required")
+	}
+	hpcloud.OBJECT_STORE = *objectStore
+
+	authenticate := func() (*hpcloud.Access, error) {
+		return hpcloud.Authenticate(*user, password, *tenant)
+	}
+	a, err := authenticate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gw := s3gateway.New(*a)
+	gw.Refresh = authenticate
+	gw.MaxBodySize = *maxBody
+
+	log.Printf("Serving S3 on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, gw))
+}
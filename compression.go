@@ -0,0 +1,167 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/AeroNotix/hpcloud/internal/zstd"
+)
+
+/* Content encodings understood by the Compression codec. */
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+const originalSizeHeader = "X-Object-Meta-Original-Size"
+
+// Compressor provides the streams for a single content encoding.
+type Compressor interface {
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		Gzip: gzipCompressor{},
+		Zstd: zstdCompressor{},
+	}
+)
+
+// RegisterCompressor makes a Compressor available to the Compression
+// codec under the supplied content encoding, replacing any already
+// registered for it.
+func RegisterCompressor(encoding string, c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[encoding] = c
+}
+
+func compressorFor(encoding string) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[encoding]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No compressor registered for: %s", encoding))
+	}
+	return c, nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w), nil
+}
+
+func (zstdCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zstd.NewReader(r), nil
+}
+
+// Compression is an ObjectCodec which compresses objects before they
+// are uploaded, setting the Content-Encoding header and recording the
+// uncompressed size in the object's metadata.
+//
+// When downloading, any object with a Content-Encoding which has a
+// registered Compressor is decompressed, regardless of the Encoding
+// the codec was created with.
+type Compression struct {
+	Encoding string
+}
+
+func (c Compression) Encode(contents []byte, header http.Header) ([]byte, error) {
+	comp, err := compressorFor(c.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	w, err := comp.NewWriter(b)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(contents); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	header.Set("Content-Encoding", c.Encoding)
+	header.Set(originalSizeHeader, strconv.Itoa(len(contents)))
+	return b.Bytes(), nil
+}
+
+func (c Compression) Decode(contents []byte, header http.Header) ([]byte, error) {
+	encoding := header.Get("Content-Encoding")
+	if encoding == "" || encoding == "identity" {
+		return contents, nil
+	}
+	comp, err := compressorFor(encoding)
+	if err != nil {
+		return nil, err
+	}
+	r, err := comp.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	plain, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if size := header.Get(originalSizeHeader); size != "" {
+		if size != strconv.Itoa(len(plain)) {
+			return nil, errors.New(fmt.Sprintf(
+				"Decompressed size %d does not match the original size %s.", len(plain), size,
+			))
+		}
+	}
+	header.Del("Content-Encoding")
+	return plain, nil
+}
@@ -37,49 +37,96 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-/* Server flavours Smallest to Largest */
-type Flavor int
+/*
+  Server flavours Smallest to Largest.
+
+  Flavours are referred to by their ID, which some deployments give as
+  a number and others as a string, so it is kept as a string and
+  decoded from either.
+
+  These are the flavours the HPCloud launched with, they are kept as
+  convenience values. FindFlavor looks flavours up in the live catalog.
+*/
+type Flavor string
 
 const (
-	XSmall = Flavor(100) + iota
-	Small
-	Medium
-	Large
-	XLarge
-	DblXLarge
+	XSmall    Flavor = "100"
+	Small     Flavor = "101"
+	Medium    Flavor = "102"
+	Large     Flavor = "103"
+	XLarge    Flavor = "104"
+	DblXLarge Flavor = "105"
 )
 
-/* Available images */
-type ServerImage int
+/*
+  UnmarshalJSON implements the Unmarshaler interface for the Flavor
+  type, accepting an ID given as either a string or a number.
+*/
+func (f *Flavor) UnmarshalJSON(b []byte) error {
+	id, err := decodeID(b)
+	*f = Flavor(id)
+	return err
+}
+
+/*
+  decodeID decodes an ID which may be given as a string, a number or
+  null, which is decoded as an empty ID.
+*/
+func decodeID(b []byte) (string, error) {
+	var s *string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if s == nil {
+			return "", nil
+		}
+		return *s, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+/*
+  Images are referred to by their ID, which is a number on older
+  deployments and a UUID on newer ones.
+
+  The images below are the ones the HPCloud launched with, they are
+  kept as convenience values. FindImage looks images up in the live
+  catalog.
+*/
+type ServerImage string
 
 var (
-	UbuntuLucid10_04Kernel    = ServerImage(1235)
-	UbuntuLucid10_04          = ServerImage(1236)
-	UbuntuMaverick10_10Kernel = ServerImage(1237)
-	UbuntuMaverick10_10       = ServerImage(1238)
-	UbuntuNatty11_04Kernel    = ServerImage(1239)
-	UbuntuNatty11_04          = ServerImage(1240)
-	UbuntuOneiric11_10        = ServerImage(5579)
-	UbuntuPrecise12_04        = ServerImage(8419)
-	CentOS5_8Server64         = ServerImage(54021)
-	CentOS6_2Server64Kernel   = ServerImage(1356)
-	CentOS6_2Server64Ramdisk  = ServerImage(1357)
-	CentOS6_2Server64         = ServerImage(1358)
-	DebianSqueeze6_0_3Kernel  = ServerImage(1359)
-	DebianSqueeze6_0_3Ramdisk = ServerImage(1360)
-	DebianSqueeze6_0_3Server  = ServerImage(1361)
-	Fedora16Server64          = ServerImage(16291)
-	BitNamiDrupal7_14_0       = ServerImage(22729)
-	BitNamiWebPack1_2_0       = ServerImage(22731)
-	BitNamiDevPack1_0_0       = ServerImage(4654)
-	ActiveStateStackatov1_2_6 = ServerImage(14345)
-	ActiveStateStackatov2_2_2 = ServerImage(59297)
-	ActiveStateStackatov2_2_3 = ServerImage(60815)
-	EnterpriseDBPPAS9_1_2     = ServerImage(9953)
-	EnterpriseDBPSQL9_1_3     = ServerImage(9995)
+	UbuntuLucid10_04Kernel    = ServerImage("1235")
+	UbuntuLucid10_04          = ServerImage("1236")
+	UbuntuMaverick10_10Kernel = ServerImage("1237")
+	UbuntuMaverick10_10       = ServerImage("1238")
+	UbuntuNatty11_04Kernel    = ServerImage("1239")
+	UbuntuNatty11_04          = ServerImage("1240")
+	UbuntuOneiric11_10        = ServerImage("5579")
+	UbuntuPrecise12_04        = ServerImage("8419")
+	CentOS5_8Server64         = ServerImage("54021")
+	CentOS6_2Server64Kernel   = ServerImage("1356")
+	CentOS6_2Server64Ramdisk  = ServerImage("1357")
+	CentOS6_2Server64         = ServerImage("1358")
+	DebianSqueeze6_0_3Kernel  = ServerImage("1359")
+	DebianSqueeze6_0_3Ramdisk = ServerImage("1360")
+	DebianSqueeze6_0_3Server  = ServerImage("1361")
+	Fedora16Server64          = ServerImage("16291")
+	BitNamiDrupal7_14_0       = ServerImage("22729")
+	BitNamiWebPack1_2_0       = ServerImage("22731")
+	BitNamiDevPack1_0_0       = ServerImage("4654")
+	ActiveStateStackatov1_2_6 = ServerImage("14345")
+	ActiveStateStackatov2_2_2 = ServerImage("59297")
+	ActiveStateStackatov2_2_3 = ServerImage("60815")
+	EnterpriseDBPPAS9_1_2     = ServerImage("9953")
+	EnterpriseDBPSQL9_1_3     = ServerImage("9995")
 )
 
 /*
@@ -120,7 +167,7 @@ type Server struct {
 	MinCount       int               `json:"min_count"`
 	Name           string            `json:"name"`
 	Key            string            `json:"key_name"`
-	Personality    string            `json:"personality"`
+	Personality    Personality       `json:"personality"`
 	UserData       string            `json:"user_data"`
 	SecurityGroups []SecurityGroup   `json:"security_groups"`
 	Links          []Link            `json:"links"`
@@ -133,16 +180,17 @@ type createImageRequest struct {
 }
 
 type imageRequest struct {
-	Metadata *map[string]string `json:"metadata"`
-	Name     string             `json:"image"`
+	Name     string             `json:"name"`
+	Metadata *map[string]string `json:"metadata,omitempty"`
 }
 
+/*
+  MarshalJSON checks the metadata keys and values are within the
+  255 byte limit before encoding the request. Empty metadata is
+  left out.
+*/
 func (c createImageRequest) MarshalJSON() ([]byte, error) {
-	output_buffer := bytes.NewBufferString(`{"createImage":{`)
-	output_buffer.WriteString(fmt.Sprintf(`"name": "%s"`, c.C.Name))
-	if c.C.Metadata != nil && len(*c.C.Metadata) > 0 {
-		metadata_buffer := bytes.NewBufferString("metadata:{")
-		cnt := 0
+	if c.C.Metadata != nil {
 		for k, v := range *c.C.Metadata {
 			if len(k) > 255 {
 				return nil, errors.New(fmt.Sprintf("Key: %s has a length >255", k))
@@ -150,21 +198,13 @@ func (c createImageRequest) MarshalJSON() ([]byte, error) {
 			if len(v) > 255 {
 				return nil, errors.New(fmt.Sprintf("Value: %s has a length >255", v))
 			}
-			metadata_buffer.WriteString(
-				fmt.Sprintf(`"%s":"%s"`, k, v),
-			)
-			if cnt+1 != len(*c.C.Metadata) {
-				metadata_buffer.WriteString(",")
-				cnt++
-			} else {
-				metadata_buffer.WriteString("}")
-			}
 		}
-		output_buffer.WriteString(",")
-		output_buffer.WriteString(metadata_buffer.String())
+		if len(*c.C.Metadata) == 0 {
+			c.C.Metadata = nil
+		}
 	}
-	output_buffer.WriteString("}}")
-	return output_buffer.Bytes(), nil
+	type request createImageRequest
+	return json.Marshal(request(c))
 }
 
 /*
@@ -196,19 +236,65 @@ type ServerResponse struct {
 	} `json:"server"`
 }
 
+/*
+  Addresses are the addresses of a server by the network they are on.
+  The private and public networks are common enough to have their own
+  fields, every network, including those, is in Networks.
+*/
 type Addresses struct {
-	Private []Address
+	Private  []Address
+	Public   []Address
+	Networks map[string][]Address
+}
+
+func (a *Addresses) UnmarshalJSON(b []byte) error {
+	networks := map[string][]Address{}
+	err := json.Unmarshal(b, &networks)
+	if err != nil {
+		return err
+	}
+	a.Private = networks["private"]
+	a.Public = networks["public"]
+	a.Networks = networks
+	return nil
+}
+
+/*
+  All returns the addresses on every network, ordered by the name of
+  the network.
+*/
+func (a Addresses) All() []Address {
+	names := make([]string, 0, len(a.Networks))
+	for name := range a.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := []Address{}
+	for _, name := range names {
+		all = append(all, a.Networks[name]...)
+	}
+	return all
 }
 
+/*
+  Type is set by deployments which say whether the address is
+  "fixed" or a "floating" one.
+*/
 type Address struct {
-	Addr    string
-	Version int64
+	Addr    string `json:"addr"`
+	Version int64  `json:"version"`
+	Type    string `json:"OS-EXT-IPS:type"`
 }
 
 /*
   CreateServer creates a new server in the HPCloud using the
   settings found in the Server instance passed to this function.
 
+  Only the server's required fields are checked before the request is
+  made. Checking the flavour and image against the catalog is opt-in,
+  call ValidateServer first, or use FindFlavor and FindImage to fill
+  them in, to catch unknown ones without a failed request.
+
   This function implements the interface as described in:-
   * https://docs.hpcloud.com/api/compute/
   * section 4.4.5.2 Create Server
@@ -259,25 +345,15 @@ func (a Access) DeleteServer(server_id string) error {
 }
 
 /*
-  RebootServer will reboot the server with the `server_id`.
+  RebootServer will hard reboot the server with the `server_id`,
+  RebootServerWithType can soft reboot it instead.
 
   This function implements the interface described in:-
   * https://docs.hpcloud.com/api/compute/
   * Section 4.4.7.1 Reboot Server
 */
 func (a Access) RebootServer(server_id string) error {
-	/*
-			 The docs mention that a hard reboot will be used
-		     no matter what, so there's no point making a type
-		     or make the type of reboot an option
-	*/
-	s := `{"reboot":{"type":"HARD"}}`
-	_, err := a.baseComputeRequest(
-		fmt.Sprintf("servers/%s/action", server_id),
-		"POST", strings.NewReader(s),
-	)
-	return err
-
+	return a.RebootServerWithType(server_id, HardReboot)
 }
 
 /*
@@ -342,20 +418,16 @@ func (a Access) ListImage(image_id string) (*Image, error) {
 }
 
 func (a Access) GetConsoleOutput(server_id string, length int) (string, error) {
-	jsonbody := fmt.Sprintf(
-		`{"os-getConsoleOutput":{"length":%d}}`, length,
-	)
-	body, err := a.baseComputeRequest(
-		fmt.Sprintf("servers/%s/action", server_id), "POST", strings.NewReader(jsonbody),
-	)
-	if err != nil {
-		return "", err
+	type consoleOutput struct {
+		Length int `json:"length"`
 	}
 	type Output struct {
 		Output_ string `json:"output"`
 	}
 	o := &Output{}
-	err = json.Unmarshal(body, o)
+	err := a.serverAction(server_id, map[string]consoleOutput{
+		"os-getConsoleOutput": {length},
+	}, o)
 	return o.Output_, err
 }
 
@@ -382,10 +454,20 @@ func (a Access) GetVNCConsole(server_id int64) (string, error) {
 }
 
 //  ListServerAddresses will list all the addresses associated with
-//  the provided server_id.
+//  the provided server_id, on every network.
 func (a Access) ListServerAddresses(server_id int64) ([]Address, error) {
+	addresses, err := a.ListServerNetworks(strconv.FormatInt(server_id, 10))
+	if err != nil {
+		return nil, err
+	}
+	return addresses.All(), nil
+}
+
+//  ListServerNetworks lists the addresses associated with the
+//  provided server_id by the network they are on.
+func (a Access) ListServerNetworks(server_id string) (*Addresses, error) {
 	body, err := a.baseComputeRequest(
-		fmt.Sprintf("servers/%d/ips", server_id), "GET", nil,
+		fmt.Sprintf("servers/%s/ips", server_id), "GET", nil,
 	)
 	if err != nil {
 		return nil, err
@@ -395,8 +477,10 @@ func (a Access) ListServerAddresses(server_id int64) ([]Address, error) {
 	}
 	o := &Output{}
 	err = json.Unmarshal(body, o)
-	return o.Addresses.Private, nil
-
+	if err != nil {
+		return nil, err
+	}
+	return &o.Addresses, nil
 }
 
 /*
@@ -425,96 +509,78 @@ func (a Access) baseComputeRequest(url, method string, b io.Reader) ([]byte, err
   sure that zero-values are converted to known good values.
 
   As such:
-    * FlavorRef is checked if it's a valid reference.
+    * FlavorRef is checked to be set, ValidateServer checks it
+      against the flavours in the catalog.
     * Ditto for ImageRef.
     * Name cannot be blank.
     * If the key is missing, it'll not put anything in.
     * The config_drive defaults to false anyway, no need
       to send a false value.
     * Min/MaxCount are ignored if they are zero.
-    * UserData is ignored if it's a blank string.
-    * Personality is ignored if it's a blank string.
+    * UserData is ignored if it's a blank string, and can't be
+      more than MaxUserDataSize once encoded. UserData.Build
+      builds it for cloud-init.
+    * Personality is ignored if it's empty, and has to be within
+      the personality limits.
     * Metadata/SecurityGroups are ignored if they have len(0)
 */
 func (s Server) MarshalJSON() ([]byte, error) {
-	b := bytes.NewBufferString("")
-	b.WriteString(`{"server":{`)
-	/* The available images are 100-105, x-small to x-large. */
-	if s.FlavorRef < 100 || s.FlavorRef > 105 {
+	/*
+	  Whether the flavour and image exist depends on the catalog,
+	  which ValidateServer checks, here we can only check they're set.
+	*/
+	if s.FlavorRef == "" {
 		return []byte{},
-			errors.New("Flavor Reference refers to a non-existant flavour.")
-	} else {
-		b.WriteString(fmt.Sprintf(`"flavorRef":%d`, s.FlavorRef))
+			errors.New("A flavor reference is required.")
 	}
-	if s.ImageRef == 0 {
+	if s.ImageRef == "" {
 		return []byte{},
 			errors.New("An image name is required.")
-	} else {
-		b.WriteString(fmt.Sprintf(`,"imageRef":%d`, s.ImageRef))
 	}
 	if s.Name == "" {
 		return []byte{},
 			errors.New("A name is required")
-	} else {
-		b.WriteString(fmt.Sprintf(`,"name":"%s"`, s.Name))
 	}
-
-	/* Optional items */
-	/* The max size of a personality string is 255 bytes. */
-	if len(s.Personality) > 255 {
-		return []byte{},
-			errors.New("Server's personality cannot have >255 bytes.")
-	} else if s.Personality != "" {
-		b.WriteString(fmt.Sprintf(`,"personality":"%s",`, s.Personality))
+	if err := checkUserDataSize(s.UserData); err != nil {
+		return []byte{}, err
 	}
-	if s.Key != "" {
-		b.WriteString(fmt.Sprintf(`,"key_name":"%s"`, s.Key))
+	if err := s.Personality.validate(); err != nil {
+		return []byte{}, err
 	}
-	if s.ConfigDrive {
-		b.WriteString(`,"config_drive": true`)
+
+	type securityGroup struct {
+		Name string `json:"name"`
 	}
-	if s.MinCount > 0 {
-		b.WriteString(fmt.Sprintf(`,"min_count":%d`, s.MinCount))
+	type server struct {
+		FlavorRef      Flavor            `json:"flavorRef"`
+		ImageRef       ServerImage       `json:"imageRef"`
+		Name           string            `json:"name"`
+		Personality    Personality       `json:"personality,omitempty"`
+		Key            string            `json:"key_name,omitempty"`
+		ConfigDrive    bool              `json:"config_drive,omitempty"`
+		MinCount       int               `json:"min_count,omitempty"`
+		MaxCount       int               `json:"max_count,omitempty"`
+		UserData       string            `json:"user_data,omitempty"`
+		Metadata       map[string]string `json:"metadata,omitempty"`
+		SecurityGroups []securityGroup   `json:"security_groups,omitempty"`
 	}
-	if s.MaxCount > 0 {
-		b.WriteString(fmt.Sprintf(`,"max_count":%d`, s.MaxCount))
+	out := server{
+		FlavorRef:   s.FlavorRef,
+		ImageRef:    s.ImageRef,
+		Name:        s.Name,
+		Personality: s.Personality,
+		Key:         s.Key,
+		ConfigDrive: s.ConfigDrive,
+		MinCount:    s.MinCount,
+		MaxCount:    s.MaxCount,
+		Metadata:    s.Metadata,
 	}
 	if s.UserData != "" {
 		/* user_data needs to be base64'd */
-		newb := make([]byte, 0, len(s.UserData))
-		base64.StdEncoding.Encode([]byte(s.UserData), newb)
-		b.WriteString(fmt.Sprintf(`,"user_data": "%s",`, string(newb)))
-	}
-
-	/* Ignore the metadata if there isn't any, it's optional. */
-	if len(s.Metadata) > 0 {
-		fmt.Println(len(s.Metadata))
-		b.WriteString(`,"metadata":{`)
-		cnt := 0
-		for key, value := range s.Metadata {
-			b.WriteString(fmt.Sprintf(`"%s": "%s"`, key, value))
-			if cnt+1 != len(s.Metadata) {
-				b.WriteString(",")
-				cnt++
-			} else {
-				b.WriteString("}")
-			}
-		}
+		out.UserData = base64.StdEncoding.EncodeToString([]byte(s.UserData))
 	}
-	/* Ignore the Security Groups if there isn't any, it's optional. */
-	if len(s.SecurityGroups) > 0 {
-		b.WriteString(`,"security_groups":[`)
-		cnt := 0
-		for _, sg := range s.SecurityGroups {
-			b.WriteString(fmt.Sprintf(`{"name": "%s"}`, sg.Name))
-			if cnt+1 != len(s.SecurityGroups) {
-				b.WriteString(",")
-				cnt++
-			} else {
-				b.WriteString("]")
-			}
-		}
+	for _, sg := range s.SecurityGroups {
+		out.SecurityGroups = append(out.SecurityGroups, securityGroup{sg.Name})
 	}
-	b.WriteString("}}")
-	return b.Bytes(), nil
+	return json.Marshal(map[string]server{"server": out})
 }
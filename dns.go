@@ -218,7 +218,6 @@ func (a Access) baseDNSRequest(url, method string, b io.Reader) ([]byte, error)
 		}
 		return nil, errors.New(ise.Message())
 	default:
-		panic(fmt.Sprintf("Unhandled response type: %d", resp.StatusCode))
+		return nil, StatusError{http.StatusOK, resp.StatusCode}
 	}
-	panic("Unreachable!")
 }
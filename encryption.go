@@ -0,0 +1,229 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+/* Object metadata used to record how an object was encrypted. */
+const (
+	cryptoKeyIDHeader           = "X-Object-Meta-Crypto-Key-Id"
+	cryptoNonceHeader           = "X-Object-Meta-Crypto-Nonce"
+	cryptoWrappedKeyHeader      = "X-Object-Meta-Crypto-Wrapped-Key"
+	cryptoContentEncodingHeader = "X-Object-Meta-Crypto-Content-Encoding"
+)
+
+// KeyProvider supplies the master keys which are used to wrap the
+// per-object data keys.
+//
+// CurrentKey returns the key which new uploads should be encrypted with
+// and the ID which identifies it, Key returns the key for a previously
+// used ID so that older objects can still be decrypted after a key has
+// been rotated. Keys must be 16, 24 or 32 bytes long.
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider backed by a fixed set of keys. Current is
+// the ID of the key used for new uploads.
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (s StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := s.Key(s.Current)
+	return s.Current, key, err
+}
+
+func (s StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := s.Keys[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No key with the ID: %s", id))
+	}
+	return key, nil
+}
+
+// Encryption is an ObjectCodec which encrypts objects before they are
+// uploaded and decrypts them when they are downloaded.
+//
+// Each object is encrypted with AES-GCM under a fresh random data key,
+// that data key is itself encrypted with the provider's current key and
+// stored in the object's metadata along with the key ID and nonce.
+// Since the Etag is taken of what is uploaded, the end-to-end integrity
+// check covers the ciphertext.
+//
+// The key ID, wrapped key and original Content-Encoding are bound to
+// the ciphertext as additional authenticated data, so tampering with
+// any of them makes decoding fail.
+//
+// Objects which have no encryption metadata are rejected when
+// downloading, since anyone able to write to the container could
+// otherwise replace an object with plaintext. Set AllowUnencrypted to
+// pass such objects through as-is, e.g. while migrating a container.
+type Encryption struct {
+	Keys             KeyProvider
+	AllowUnencrypted bool
+}
+
+func (e Encryption) Encode(contents []byte, header http.Header) ([]byte, error) {
+	id, master, err := e.Keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := wrapKey(master, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	/*
+	  The stored object is ciphertext, so a Content-Encoding would be
+	  a lie to anything reading it. We keep it to restore on decode.
+	*/
+	if ce := header.Get("Content-Encoding"); ce != "" {
+		header.Set(cryptoContentEncodingHeader, ce)
+		header.Del("Content-Encoding")
+	}
+	header.Set(cryptoKeyIDHeader, id)
+	header.Set(cryptoWrappedKeyHeader, base64.StdEncoding.EncodeToString(wrapped))
+	header.Set(cryptoNonceHeader, base64.StdEncoding.EncodeToString(nonce))
+	return gcm.Seal(nil, nonce, contents, cryptoAdditionalData(header)), nil
+}
+
+func (e Encryption) Decode(contents []byte, header http.Header) ([]byte, error) {
+	id := header.Get(cryptoKeyIDHeader)
+	if id == "" {
+		if e.AllowUnencrypted {
+			return contents, nil
+		}
+		return nil, errors.New("Object is not encrypted.")
+	}
+	master, err := e.Keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(header.Get(cryptoWrappedKeyHeader))
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(header.Get(cryptoNonceHeader))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := unwrapKey(master, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("Object has an invalid encryption nonce.")
+	}
+	plain, err := gcm.Open(nil, nonce, contents, cryptoAdditionalData(header))
+	if err != nil {
+		return nil, err
+	}
+	if ce := header.Get(cryptoContentEncodingHeader); ce != "" {
+		header.Set("Content-Encoding", ce)
+	}
+	return plain, nil
+}
+
+// cryptoAdditionalData returns the encryption metadata which is
+// authenticated along with an object's contents. Each value is length
+// prefixed so that no two sets of metadata encode the same way.
+func cryptoAdditionalData(header http.Header) []byte {
+	var ad []byte
+	for _, h := range []string{
+		cryptoKeyIDHeader,
+		cryptoWrappedKeyHeader,
+		cryptoContentEncodingHeader,
+	} {
+		v := header.Get(h)
+		ad = append(ad, fmt.Sprintf("%d:%s", len(v), v)...)
+	}
+	return ad
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKey encrypts a data key with the master key, prefixing the result
+// with the nonce used. unwrapKey reverses it.
+func wrapKey(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func unwrapKey(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Wrapped key is too short.")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
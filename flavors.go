@@ -0,0 +1,166 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// FlavorDetail is a compute flavor along with the resources its
+// servers are given.
+type FlavorDetail struct {
+	ID    Flavor `json:"id"`
+	Name  string `json:"name"`
+	Links []Link `json:"links"`
+	// RAM is in megabytes, Disk and Ephemeral in gigabytes.
+	RAM       int `json:"ram"`
+	VCPUs     int `json:"vcpus"`
+	Disk      int `json:"disk"`
+	Ephemeral int `json:"OS-FLV-EXT-DATA:ephemeral"`
+}
+
+// Resources returns the resources the flavor gives its servers.
+func (f FlavorDetail) Resources() Resources {
+	return Resources{RAM: f.RAM, VCPUs: f.VCPUs, Disk: f.Disk}
+}
+
+// Resources returns the resources the flavor gives its instances. The
+// database flavors do not say how much disk they have.
+func (f DBFlavor) Resources() Resources {
+	return Resources{RAM: f.Ram, VCPUs: f.Vcpu}
+}
+
+// ListFlavorsDetail lists the available flavors along with their
+// resources.
+func (a Access) ListFlavorsDetail() ([]FlavorDetail, error) {
+	body, err := a.baseComputeRequest("flavors/detail", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		F []FlavorDetail `json:"flavors"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	return o.F, err
+}
+
+// Resources are the resources of a flavor, RAM in megabytes and Disk
+// in gigabytes.
+type Resources struct {
+	RAM   int
+	VCPUs int
+	Disk  int
+}
+
+// covers reports whether r has at least the resources of min.
+func (r Resources) covers(min Resources) bool {
+	return r.RAM >= min.RAM && r.VCPUs >= min.VCPUs && r.Disk >= min.Disk
+}
+
+// smaller orders resources by RAM, then VCPUs and then Disk.
+func (r Resources) smaller(o Resources) bool {
+	if r.RAM != o.RAM {
+		return r.RAM < o.RAM
+	}
+	if r.VCPUs != o.VCPUs {
+		return r.VCPUs < o.VCPUs
+	}
+	return r.Disk < o.Disk
+}
+
+// FlavorRequirements describe the smallest flavor which will do.
+//
+// Of the flavors which have at least the Min resources the one with the
+// lowest Cost is chosen, or the smallest when Cost is nil.
+type FlavorRequirements struct {
+	Min  Resources
+	Cost func(Resources) float64
+}
+
+// choose returns the index of the best of the n flavors whose
+// resources are returned from resources, or -1 if none meet the
+// requirements.
+func (req FlavorRequirements) choose(n int, resources func(i int) Resources) int {
+	best := -1
+	for i := 0; i < n; i++ {
+		r := resources(i)
+		if !r.covers(req.Min) {
+			continue
+		}
+		if best == -1 {
+			best = i
+			continue
+		}
+		b := resources(best)
+		if req.Cost != nil {
+			if req.Cost(r) < req.Cost(b) {
+				best = i
+			}
+		} else if r.smaller(b) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (req FlavorRequirements) unmet() error {
+	return errors.New(fmt.Sprintf(
+		"No flavor has %dMB of RAM, %d VCPUs and %dGB of disk.",
+		req.Min.RAM, req.Min.VCPUs, req.Min.Disk,
+	))
+}
+
+// ChooseFlavor picks the flavor which best meets the requirements.
+func (req FlavorRequirements) ChooseFlavor(flavors []FlavorDetail) (*FlavorDetail, error) {
+	i := req.choose(len(flavors), func(i int) Resources {
+		return flavors[i].Resources()
+	})
+	if i == -1 {
+		return nil, req.unmet()
+	}
+	return &flavors[i], nil
+}
+
+// ChooseDBFlavor picks the database flavor which best meets the
+// requirements. Since database flavors have no disk, requirements
+// with any disk are never met.
+func (req FlavorRequirements) ChooseDBFlavor(flavors DBFlavors) (*DBFlavor, error) {
+	i := req.choose(len(flavors.Flavors), func(i int) Resources {
+		return flavors.Flavors[i].Resources()
+	})
+	if i == -1 {
+		return nil, req.unmet()
+	}
+	return &flavors.Flavors[i], nil
+}
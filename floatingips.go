@@ -0,0 +1,171 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// FloatingIP is a public address which can be moved between servers.
+// InstanceID and FixedIP are only set while it is associated with a
+// server.
+//
+// Like the server IDs taken by the rest of the floating IP calls, the
+// IDs are strings, and are decoded from either strings or numbers.
+type FloatingIP struct {
+	ID         string `json:"id"`
+	IP         string `json:"ip"`
+	FixedIP    string `json:"fixed_ip"`
+	InstanceID string `json:"instance_id"`
+	Pool       string `json:"pool"`
+}
+
+// UnmarshalJSON implements the Unmarshaler interface, decoding IDs
+// given as either strings or numbers.
+func (f *FloatingIP) UnmarshalJSON(b []byte) error {
+	type floatingIP FloatingIP
+	var wire struct {
+		floatingIP
+		ID         json.RawMessage `json:"id"`
+		InstanceID json.RawMessage `json:"instance_id"`
+	}
+	if err := json.Unmarshal(b, &wire); err != nil {
+		return err
+	}
+	*f = FloatingIP(wire.floatingIP)
+	var err error
+	if len(wire.ID) > 0 {
+		if f.ID, err = decodeID(wire.ID); err != nil {
+			return err
+		}
+	}
+	if len(wire.InstanceID) > 0 {
+		f.InstanceID, err = decodeID(wire.InstanceID)
+	}
+	return err
+}
+
+// ListFloatingIPPools lists the names of the pools floating IPs can be
+// allocated from.
+func (a Access) ListFloatingIPPools() ([]string, error) {
+	body, err := a.baseComputeRequest("os-floating-ip-pools", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		P []struct {
+			Name string `json:"name"`
+		} `json:"floating_ip_pools"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	pools := make([]string, len(o.P))
+	for i, p := range o.P {
+		pools[i] = p.Name
+	}
+	return pools, nil
+}
+
+// AllocateFloatingIP allocates a floating IP to the account from the
+// pool, or the default pool when pool is empty.
+func (a Access) AllocateFloatingIP(pool string) (*FloatingIP, error) {
+	type allocate struct {
+		Pool string `json:"pool,omitempty"`
+	}
+	b, err := json.Marshal(allocate{pool})
+	if err != nil {
+		return nil, err
+	}
+	body, err := a.baseComputeRequest("os-floating-ips", "POST", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		F FloatingIP `json:"floating_ip"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.F, nil
+}
+
+// ListFloatingIPs lists the floating IPs allocated to the account.
+func (a Access) ListFloatingIPs() ([]FloatingIP, error) {
+	body, err := a.baseComputeRequest("os-floating-ips", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		F []FloatingIP `json:"floating_ips"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	return o.F, err
+}
+
+// AssociateFloatingIP associates the floating IP address with the
+// server, which makes the server reachable on it.
+func (a Access) AssociateFloatingIP(server_id, ip string) error {
+	return a.floatingIPAction(server_id, "addFloatingIp", ip)
+}
+
+// DisassociateFloatingIP removes the floating IP address from the
+// server, it stays allocated to the account.
+func (a Access) DisassociateFloatingIP(server_id, ip string) error {
+	return a.floatingIPAction(server_id, "removeFloatingIp", ip)
+}
+
+func (a Access) floatingIPAction(server_id, action, ip string) error {
+	if net.ParseIP(ip) == nil {
+		return errors.New(fmt.Sprintf("%s is not an IP address.", ip))
+	}
+	type address struct {
+		Address string `json:"address"`
+	}
+	return a.serverAction(server_id, map[string]address{action: {ip}}, nil)
+}
+
+// ReleaseFloatingIP gives the floating IP back to its pool.
+func (a Access) ReleaseFloatingIP(id string) error {
+	_, err := a.baseComputeRequest(
+		fmt.Sprintf("os-floating-ips/%s", id), "DELETE", nil,
+	)
+	return err
+}
@@ -47,69 +47,66 @@ import (
   to have a base method which most requests Go through.
 */
 func (a Access) baseRequest(url, method string, b io.Reader) ([]byte, error) {
+	body, _, err := a.baseRequestWithHeaders(url, method, b, nil)
+	return body, err
+}
+
+/*
+  baseRequestWithHeaders is baseRequest for the resources, such as the
+  CDN, which take and return their data in headers. The extra headers
+  are added to the request and the response's headers are returned.
+
+  Failures which are not described in JSON are returned as a
+  StatusError.
+*/
+func (a Access) baseRequestWithHeaders(url, method string, b io.Reader, header http.Header) ([]byte, http.Header, error) {
 	req, err := http.NewRequest(method, url, b)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if a.Authenticated {
 		req.Header.Add("X-Auth-Token", a.AuthToken())
 	}
 	req.Header.Add("Content-type", "application/json")
 	req.Header.Add("Accept", "application/json")
+	for key, value := range header {
+		req.Header[key] = value
+	}
 	resp, err := a.Client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	var failure FailureResponse
 	switch resp.StatusCode {
 	case
 		http.StatusNoContent,
+		http.StatusCreated,
 		http.StatusAccepted,
 		http.StatusNonAuthoritativeInfo,
 		http.StatusOK:
-		return body, nil
+		return body, resp.Header, nil
 	case http.StatusNotFound:
-		nf := &NotFound{}
-		err = json.Unmarshal(body, nf)
-		if err != nil {
-			return nil, err
-		}
-		return nil, errors.New(nf.Message())
+		failure = &NotFound{}
 	case http.StatusBadRequest:
-		br := &BadRequest{}
-		err = json.Unmarshal(body, br)
-		if err != nil {
-			return nil, err
-		}
-		return nil, errors.New(br.Message())
+		failure = &BadRequest{}
 	case http.StatusUnauthorized:
-		u := &Unauthorized{}
-		err = json.Unmarshal(body, &u)
-		if err != nil {
-			return nil, err
-		}
-		return nil, errors.New(u.Message())
+		failure = &Unauthorized{}
 	case http.StatusForbidden:
-		f := &Forbidden{}
-		err = json.Unmarshal(body, &f)
-		if err != nil {
-			return nil, err
-		}
-		return nil, errors.New(f.Message())
+		failure = &Forbidden{}
 	case http.StatusInternalServerError:
-		ise := &InternalServerError{}
-		err = json.Unmarshal(body, &ise)
-		if err != nil {
-			return nil, err
-		}
-		return nil, errors.New(ise.Message())
+		failure = &InternalServerError{}
 	default:
-		panic(fmt.Sprintf("Unhandled response type: %d", resp.StatusCode))
+		return nil, nil, StatusError{http.StatusOK, resp.StatusCode}
+	}
+	if json.Unmarshal(body, failure) != nil || failure.Message() == "" {
+		return nil, nil, StatusError{http.StatusOK, resp.StatusCode}
 	}
-	panic("Unreachable!")
+	return nil, nil, errors.New(failure.Message())
 }
 
 /*
@@ -191,13 +188,27 @@ type InternalServerError struct {
 	} `json:"internalServerError"`
 }
 
+/*
+ StatusError is returned when a resource which does not describe its
+ failures in JSON, such as the object store, responds with a status
+ code other than the one expected.
+*/
+type StatusError struct {
+	Expected   int
+	StatusCode int
+}
+
+func (s StatusError) Error() string {
+	return fmt.Sprintf("Non-%d status code: %d", s.Expected, s.StatusCode)
+}
+
 type SubToken struct {
 	ID string `json:"id"`
 }
 
 type Flavor_ struct {
 	Name  string `json:"name"`
-	ID    int64  `json:"id"`
+	ID    Flavor `json:"id"`
 	Links []Link `json:"links"`
 }
 
@@ -36,6 +36,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 /*
@@ -162,6 +163,12 @@ type Access struct {
 	AccessKey     string
 	TenantID      string
 	Client        http.Client
+	/*
+	  ObjectStore overrides the OBJECT_STORE endpoint for this Access,
+	  which allows object stores in more than one region to be used
+	  at once.
+	*/
+	ObjectStore string
 }
 
 /*
@@ -172,6 +179,19 @@ func (a Access) AuthToken() string {
 	return a.A.Token.ID
 }
 
+/*
+ TokenExpired reports whether the auth_token will have expired within
+ the duration given. A token with no parseable expiry is assumed to
+ still be valid.
+*/
+func (a Access) TokenExpired(within time.Duration) bool {
+	expires, err := time.Parse(time.RFC3339, a.A.Token.Expires)
+	if err != nil {
+		return false
+	}
+	return time.Now().Add(within).After(expires)
+}
+
 type Login struct {
 	Auth auth `json:"auth"`
 }
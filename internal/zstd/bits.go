@@ -0,0 +1,166 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zstd
+
+import "math/bits"
+
+// forwardBitReader reads a bitstream from the least significant bit
+// of its first byte, as FSE table descriptions are written. Reading
+// past the end gives zeros, which callers check for with overread.
+type forwardBitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *forwardBitReader) peek(n uint) uint32 {
+	var v uint64
+	off := int(r.pos / 8)
+	for i := 0; i < 5 && off+i < len(r.data); i++ {
+		v |= uint64(r.data[off+i]) << (8 * uint(i))
+	}
+	return uint32(v>>(r.pos%8)) & (1<<n - 1)
+}
+
+func (r *forwardBitReader) skip(n uint) {
+	r.pos += n
+}
+
+func (r *forwardBitReader) read(n uint) uint32 {
+	v := r.peek(n)
+	r.skip(n)
+	return v
+}
+
+func (r *forwardBitReader) overread() bool {
+	return r.pos > 8*uint(len(r.data))
+}
+
+// reverseBitReader reads a bitstream backwards from its end, which is
+// how Huffman and FSE coded streams are read. The last byte's highest
+// set bit marks where the stream starts. Reading past the beginning
+// gives zeros and is recorded so callers can tell a stream overran.
+type reverseBitReader struct {
+	data []byte
+	/* data[:off] is yet to be loaded into bits. */
+	off  int
+	bits uint64
+	cnt  uint
+	over uint
+}
+
+func newReverseBitReader(data []byte) (*reverseBitReader, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, corrupt("bitstream")
+	}
+	last := data[len(data)-1]
+	n := uint(bits.Len8(last)) - 1
+	return &reverseBitReader{
+		data: data,
+		off:  len(data) - 1,
+		bits: uint64(last) & (1<<n - 1),
+		cnt:  n,
+	}, nil
+}
+
+func (r *reverseBitReader) fill() {
+	for r.cnt <= 56 && r.off > 0 {
+		r.off--
+		r.bits = r.bits<<8 | uint64(r.data[r.off])
+		r.cnt += 8
+	}
+}
+
+func (r *reverseBitReader) peek(n uint) uint64 {
+	if r.cnt < n {
+		r.fill()
+	}
+	if r.cnt >= n {
+		return r.bits >> (r.cnt - n) & (1<<n - 1)
+	}
+	return r.bits << (n - r.cnt) & (1<<n - 1)
+}
+
+func (r *reverseBitReader) skip(n uint) {
+	if r.cnt < n {
+		r.fill()
+	}
+	if r.cnt >= n {
+		r.cnt -= n
+	} else {
+		r.over += n - r.cnt
+		r.cnt = 0
+	}
+	r.bits &= 1<<r.cnt - 1
+}
+
+func (r *reverseBitReader) read(n uint) uint64 {
+	v := r.peek(n)
+	r.skip(n)
+	return v
+}
+
+// finished reports whether every bit was read, and no more.
+func (r *reverseBitReader) finished() bool {
+	return r.cnt == 0 && r.off == 0 && r.over == 0
+}
+
+func (r *reverseBitReader) overflowed() bool {
+	return r.over > 0
+}
+
+// bitWriter writes a bitstream from the least significant bit up, to
+// be read back with a reverseBitReader once closed.
+type bitWriter struct {
+	out  []byte
+	bits uint64
+	cnt  uint
+}
+
+func (w *bitWriter) add(v uint64, n uint) {
+	w.bits |= v & (1<<n - 1) << w.cnt
+	w.cnt += n
+	for w.cnt >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.cnt -= 8
+	}
+}
+
+/* close marks the end of the stream, which the reader starts from. */
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.cnt > 0 {
+		w.out = append(w.out, byte(w.bits))
+	}
+	w.bits, w.cnt = 0, 0
+	return w.out
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zstd
+
+import "math/bits"
+
+/* The largest accuracy logs RFC 8878 allows for each table. */
+const (
+	maxLiteralLengthLog = 9
+	maxMatchLengthLog   = 9
+	maxOffsetLog        = 8
+	maxWeightLog        = 6
+)
+
+/* The largest code of each kind of sequence symbol. */
+const (
+	maxLiteralLengthCode = 35
+	maxMatchLengthCode   = 52
+	maxOffsetCode        = 31
+)
+
+/* The predefined distributions, RFC 8878 3.1.1.3.2.2. */
+var (
+	predefinedLiteralLengths = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	predefinedMatchLengths = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	predefinedOffsets = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+const (
+	predefinedLiteralLengthLog = 6
+	predefinedMatchLengthLog   = 6
+	predefinedOffsetLog        = 5
+)
+
+// fseEntry is a state of an FSE decoding table: the symbol it decodes
+// and how to find the next state.
+type fseEntry struct {
+	sym  uint8
+	bits uint8
+	base uint16
+}
+
+type fseTable struct {
+	log     uint
+	entries []fseEntry
+}
+
+func (t *fseTable) init(r *reverseBitReader) uint16 {
+	return uint16(r.read(t.log))
+}
+
+func (t *fseTable) next(r *reverseBitReader, state uint16) uint16 {
+	e := t.entries[state]
+	return e.base + uint16(r.read(uint(e.bits)))
+}
+
+func rleTable(sym uint8) *fseTable {
+	return &fseTable{entries: []fseEntry{{sym: sym}}}
+}
+
+// readDistribution reads an FSE table description, returning the
+// normalized distribution, its accuracy log and the bytes it took.
+func readDistribution(data []byte, maxSym int, maxLog uint) ([]int16, uint, int, error) {
+	r := &forwardBitReader{data: data}
+	log := uint(r.read(4)) + 5
+	if log > maxLog {
+		return nil, 0, 0, corrupt("FSE table accuracy")
+	}
+	remaining := int32(1)<<log + 1
+	threshold := int32(1) << log
+	nbits := log + 1
+	norm := []int16{}
+	previousZero := false
+	for remaining > 1 && len(norm) <= maxSym {
+		if previousZero {
+			/* Each 2 bit flag repeats a zero up to 3 times, 3 continues. */
+			for {
+				repeat := r.read(2)
+				for i := uint32(0); i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+				if len(norm) > maxSym || r.overread() {
+					return nil, 0, 0, corrupt("FSE table")
+				}
+			}
+			if len(norm) > maxSym {
+				return nil, 0, 0, corrupt("FSE table")
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		count := int32(r.peek(nbits - 1))
+		if count < max {
+			r.skip(nbits - 1)
+		} else {
+			count = int32(r.peek(nbits))
+			if count >= threshold {
+				count -= max
+			}
+			r.skip(nbits)
+		}
+		/* A count of -1 is a probability below one, which takes a state. */
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		previousZero = count == 0
+		if remaining < 1 {
+			return nil, 0, 0, corrupt("FSE table")
+		}
+		for remaining < threshold {
+			nbits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || r.overread() {
+		return nil, 0, 0, corrupt("FSE table")
+	}
+	return norm, log, int((r.pos + 7) / 8), nil
+}
+
+// spread lays the symbols of a distribution out over the states of a
+// table, as both the decoder and encoder do. Symbols with a probability
+// below one take the highest states.
+func spread(norm []int16, log uint) ([]uint8, error) {
+	size := 1 << log
+	symbols := make([]uint8, size)
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			symbols[high] = uint8(s)
+			high--
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	pos := 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			symbols[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, corrupt("FSE table")
+	}
+	return symbols, nil
+}
+
+func newFSETable(norm []int16, log uint) (*fseTable, error) {
+	symbols, err := spread(norm, log)
+	if err != nil {
+		return nil, err
+	}
+	next := make([]uint16, len(norm))
+	for s, c := range norm {
+		if c == -1 {
+			next[s] = 1
+		} else {
+			next[s] = uint16(c)
+		}
+	}
+	t := &fseTable{log: log, entries: make([]fseEntry, len(symbols))}
+	for u, s := range symbols {
+		n := next[s]
+		next[s]++
+		nbits := log - uint(bits.Len16(n)-1)
+		t.entries[u] = fseEntry{
+			sym:  s,
+			bits: uint8(nbits),
+			base: uint16(uint(n)<<nbits - uint(len(symbols))),
+		}
+	}
+	return t, nil
+}
+
+func mustFSETable(norm []int16, log uint) *fseTable {
+	t, err := newFSETable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+var (
+	literalLengthTable = mustFSETable(predefinedLiteralLengths, predefinedLiteralLengthLog)
+	matchLengthTable   = mustFSETable(predefinedMatchLengths, predefinedMatchLengthLog)
+	offsetTable        = mustFSETable(predefinedOffsets, predefinedOffsetLog)
+)
+
+// fseEncoder is the encoding side of a table, for the Writer.
+type fseEncoder struct {
+	log    uint
+	states []uint16
+	deltas []fseDelta
+}
+
+type fseDelta struct {
+	bits  uint32
+	state int32
+}
+
+func newFSEEncoder(norm []int16, log uint) *fseEncoder {
+	symbols, err := spread(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	size := len(symbols)
+	start := make([]int, len(norm))
+	total := 0
+	for s, c := range norm {
+		start[s] = total
+		if c == -1 {
+			total++
+		} else {
+			total += int(c)
+		}
+	}
+	e := &fseEncoder{log: log, states: make([]uint16, size), deltas: make([]fseDelta, len(norm))}
+	for u, s := range symbols {
+		e.states[start[s]] = uint16(size + u)
+		start[s]++
+	}
+	total = 0
+	for s, c := range norm {
+		switch c {
+		case 0:
+			e.deltas[s].bits = uint32(log+1)<<16 - uint32(size)
+		case -1, 1:
+			e.deltas[s] = fseDelta{uint32(log)<<16 - uint32(size), int32(total - 1)}
+			total++
+		default:
+			out := log - uint(bits.Len16(uint16(c-1))-1)
+			e.deltas[s] = fseDelta{uint32(out)<<16 - uint32(c)<<out, int32(total - int(c))}
+			total += int(c)
+		}
+	}
+	return e
+}
+
+type fseState struct {
+	enc   *fseEncoder
+	state uint32
+}
+
+func (s *fseState) init(sym uint8) {
+	d := s.enc.deltas[sym]
+	out := (d.bits + 1<<15) >> 16
+	value := out<<16 - d.bits
+	s.state = uint32(s.enc.states[int32(value>>out)+d.state])
+}
+
+func (s *fseState) encode(w *bitWriter, sym uint8) {
+	d := s.enc.deltas[sym]
+	out := (s.state + d.bits) >> 16
+	w.add(uint64(s.state), uint(out))
+	s.state = uint32(s.enc.states[int32(s.state>>out)+d.state])
+}
+
+func (s *fseState) flush(w *bitWriter) {
+	w.add(uint64(s.state), s.enc.log)
+}
+
+var (
+	literalLengthEncoder = newFSEEncoder(predefinedLiteralLengths, predefinedLiteralLengthLog)
+	matchLengthEncoder   = newFSEEncoder(predefinedMatchLengths, predefinedMatchLengthLog)
+	offsetEncoder        = newFSEEncoder(predefinedOffsets, predefinedOffsetLog)
+)
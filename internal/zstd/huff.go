@@ -0,0 +1,180 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zstd
+
+import "math/bits"
+
+const maxHuffmanBits = 11
+
+type huffEntry struct {
+	sym  uint8
+	bits uint8
+}
+
+// huffTable decodes Huffman coded literals by looking up the next log
+// bits of the stream.
+type huffTable struct {
+	log     uint
+	entries []huffEntry
+}
+
+// readHuffmanTable reads a Huffman tree description, returning the
+// table and the bytes it took.
+func readHuffmanTable(data []byte) (*huffTable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, corrupt("Huffman table")
+	}
+	var weights []uint8
+	n := 1
+	if h := int(data[0]); h >= 128 {
+		/* The weights are given directly, 4 bits each. */
+		weights = make([]uint8, h-127)
+		n += (len(weights) + 1) / 2
+		if len(data) < n {
+			return nil, 0, corrupt("Huffman table")
+		}
+		for i := range weights {
+			b := data[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 15
+			}
+		}
+	} else {
+		n += h
+		if h == 0 || len(data) < n {
+			return nil, 0, corrupt("Huffman table")
+		}
+		var err error
+		if weights, err = readWeights(data[1:n]); err != nil {
+			return nil, 0, err
+		}
+	}
+	t, err := newHuffTable(weights)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, n, nil
+}
+
+// readWeights decodes FSE compressed Huffman weights, which use two
+// states in turn until the stream runs out.
+func readWeights(data []byte) ([]uint8, error) {
+	norm, log, n, err := readDistribution(data, 255, maxWeightLog)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newFSETable(norm, log)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newReverseBitReader(data[n:])
+	if err != nil {
+		return nil, err
+	}
+	states := [2]uint16{t.init(r), t.init(r)}
+	weights := []uint8{}
+	for i := 0; ; i ^= 1 {
+		if len(weights) > 255 {
+			return nil, corrupt("Huffman table")
+		}
+		weights = append(weights, t.entries[states[i]].sym)
+		states[i] = t.next(r, states[i])
+		if r.overflowed() {
+			weights = append(weights, t.entries[states[i^1]].sym)
+			return weights, nil
+		}
+	}
+}
+
+/* newHuffTable builds the table, the last symbol's weight is implied. */
+func newHuffTable(weights []uint8) (*huffTable, error) {
+	total := uint32(0)
+	for _, w := range weights {
+		if w > maxHuffmanBits {
+			return nil, corrupt("Huffman table")
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, corrupt("Huffman table")
+	}
+	log := uint(bits.Len32(total))
+	rest := uint32(1)<<log - total
+	if log > maxHuffmanBits || rest&(rest-1) != 0 || len(weights) > 255 {
+		return nil, corrupt("Huffman table")
+	}
+	weights = append(weights, uint8(bits.Len32(rest)))
+
+	var start [maxHuffmanBits + 1]int
+	for _, w := range weights {
+		if w > 0 {
+			start[w] += 1 << (w - 1)
+		}
+	}
+	next := 0
+	for w := range start {
+		next, start[w] = next+start[w], next
+	}
+	t := &huffTable{log: log, entries: make([]huffEntry, 1<<log)}
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		e := huffEntry{uint8(s), uint8(log + 1 - uint(w))}
+		for i := 0; i < 1<<(w-1); i++ {
+			t.entries[start[w]+i] = e
+		}
+		start[w] += 1 << (w - 1)
+	}
+	return t, nil
+}
+
+// decode fills out from a single Huffman coded stream.
+func (t *huffTable) decode(data, out []byte) error {
+	r, err := newReverseBitReader(data)
+	if err != nil {
+		return err
+	}
+	for i := range out {
+		e := t.entries[r.peek(t.log)]
+		out[i] = e.sym
+		r.skip(uint(e.bits))
+	}
+	if !r.finished() {
+		return corrupt("Huffman stream")
+	}
+	return nil
+}
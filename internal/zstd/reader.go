@@ -0,0 +1,570 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+/* Baselines and extra bits of the codes from 16 and 32 up, RFC 8878 3.1.1.3.2.1.1. */
+var (
+	literalLengthBase = [...]uint32{
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512,
+		1024, 2048, 4096, 8192, 16384, 32768, 65536,
+	}
+	literalLengthBits = [...]uint8{
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9,
+		10, 11, 12, 13, 14, 15, 16,
+	}
+	matchLengthBase = [...]uint32{
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259,
+		515, 1027, 2051, 4099, 8195, 16387, 32771, 65539,
+	}
+	matchLengthBits = [...]uint8{
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8,
+		9, 10, 11, 12, 13, 14, 15, 16,
+	}
+)
+
+func literalLength(code uint8) (uint32, uint) {
+	if code < 16 {
+		return uint32(code), 0
+	}
+	return literalLengthBase[code-16], uint(literalLengthBits[code-16])
+}
+
+func matchLength(code uint8) (uint32, uint) {
+	if code < 32 {
+		return uint32(code) + 3, 0
+	}
+	return matchLengthBase[code-32], uint(matchLengthBits[code-32])
+}
+
+type sequence struct {
+	literals uint32
+	match    uint32
+	offset   uint32
+}
+
+// Reader decompresses a stream of zstd frames, one block at a time.
+type Reader struct {
+	r   io.Reader
+	err error
+
+	sawFrame bool
+	inFrame  bool
+	last     bool
+	checksum bool
+	window   int64
+	size     int64
+	produced int64
+	hash     xxhash64
+
+	/* The window of earlier output, followed by the latest block. */
+	hist    []byte
+	pending []byte
+	block   []byte
+	lits    []byte
+	seqs    []sequence
+
+	/* State carried from block to block within a frame. */
+	repeats [3]uint32
+	huff    *huffTable
+	tables  [3]*fseTable
+}
+
+// NewReader returns a Reader decompressing the frames read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+func (z *Reader) Read(p []byte) (int, error) {
+	for len(z.pending) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+	n := copy(p, z.pending)
+	z.pending = z.pending[n:]
+	return n, nil
+}
+
+// Close does nothing, it lets the Reader be used as an io.ReadCloser.
+func (z *Reader) Close() error {
+	return nil
+}
+
+func (z *Reader) next() error {
+	switch {
+	case !z.inFrame:
+		return z.readFrameHeader()
+	case z.last:
+		return z.finishFrame()
+	}
+	return z.readBlock()
+}
+
+/* readFull reads len(b) bytes, the stream may not end part way. */
+func (z *Reader) readFull(b []byte) error {
+	_, err := io.ReadFull(z.r, b)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (z *Reader) readFrameHeader() error {
+	var b [14]byte
+	if _, err := io.ReadFull(z.r, b[:4]); err != nil {
+		if err == io.EOF && z.sawFrame {
+			return io.EOF
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	z.sawFrame = true
+	magic := binary.LittleEndian.Uint32(b[:4])
+	if magic&skippableMask == skippableMagic {
+		if err := z.readFull(b[:4]); err != nil {
+			return err
+		}
+		n := int64(binary.LittleEndian.Uint32(b[:4]))
+		if _, err := io.CopyN(ioutil.Discard, z.r, n); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return nil
+	}
+	if magic != frameMagic {
+		return errors.New("Not a zstd frame.")
+	}
+	if err := z.readFull(b[:1]); err != nil {
+		return err
+	}
+	descriptor := b[0]
+	if descriptor&0x08 != 0 {
+		return corrupt("frame header")
+	}
+	single := descriptor&0x20 != 0
+	sizeBytes := [4]int{0, 2, 4, 8}[descriptor>>6]
+	if single && sizeBytes == 0 {
+		sizeBytes = 1
+	}
+	windowBytes := 1
+	if single {
+		windowBytes = 0
+	}
+	dictBytes := [4]int{0, 1, 2, 4}[descriptor&3]
+	header := b[:windowBytes+dictBytes+sizeBytes]
+	if err := z.readFull(header); err != nil {
+		return err
+	}
+	if windowBytes > 0 {
+		log := uint(header[0]>>3) + 10
+		base := int64(1) << log
+		z.window = base + base/8*int64(header[0]&7)
+	}
+	var dict uint64
+	for i := dictBytes - 1; i >= 0; i-- {
+		dict = dict<<8 | uint64(header[windowBytes+i])
+	}
+	if dict != 0 {
+		return errors.New("Zstd dictionaries are not supported.")
+	}
+	z.size = -1
+	if sizeBytes > 0 {
+		var size uint64
+		for i := sizeBytes - 1; i >= 0; i-- {
+			size = size<<8 | uint64(header[windowBytes+dictBytes+i])
+		}
+		if sizeBytes == 2 {
+			size += 256
+		}
+		if size > 1<<62 {
+			return corrupt("frame header")
+		}
+		z.size = int64(size)
+	}
+	if single {
+		z.window = z.size
+	}
+	z.checksum = descriptor&0x04 != 0
+	z.inFrame = true
+	z.last = false
+	z.produced = 0
+	z.hash.reset()
+	z.hist = z.hist[:0]
+	z.repeats = [3]uint32{1, 4, 8}
+	z.huff = nil
+	z.tables = [3]*fseTable{}
+	return nil
+}
+
+func (z *Reader) finishFrame() error {
+	if z.checksum {
+		var b [4]byte
+		if err := z.readFull(b[:]); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(b[:]) != uint32(z.hash.Sum64()) {
+			return errors.New("Zstd checksum does not match the contents.")
+		}
+	}
+	if z.size >= 0 && z.produced != z.size {
+		return corrupt("frame size")
+	}
+	z.inFrame = false
+	return nil
+}
+
+func (z *Reader) readBlock() error {
+	var b [3]byte
+	if err := z.readFull(b[:]); err != nil {
+		return err
+	}
+	header := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+	z.last = header&1 != 0
+	size := int(header >> 3)
+	if size > maxBlockSize {
+		return corrupt("block size")
+	}
+	/* Only the window is needed of what has been read already. */
+	if keep := int(z.window); len(z.hist) > keep+max(keep, maxBlockSize) {
+		z.hist = z.hist[:copy(z.hist, z.hist[len(z.hist)-keep:])]
+	}
+	start := len(z.hist)
+	switch header >> 1 & 3 {
+	case blockRaw:
+		z.hist = append(z.hist, make([]byte, size)...)
+		if err := z.readFull(z.hist[start:]); err != nil {
+			return err
+		}
+	case blockRLE:
+		if err := z.readFull(b[:1]); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			z.hist = append(z.hist, b[0])
+		}
+	case blockCompressed:
+		if cap(z.block) < size {
+			z.block = make([]byte, size)
+		}
+		z.block = z.block[:size]
+		if err := z.readFull(z.block); err != nil {
+			return err
+		}
+		if err := z.decompressBlock(z.block); err != nil {
+			return err
+		}
+	default:
+		return corrupt("block type")
+	}
+	z.pending = z.hist[start:]
+	if len(z.pending) > maxBlockSize {
+		return corrupt("block size")
+	}
+	z.produced += int64(len(z.pending))
+	if z.size >= 0 && z.produced > z.size {
+		return corrupt("frame size")
+	}
+	z.hash.Write(z.pending)
+	return nil
+}
+
+func (z *Reader) decompressBlock(block []byte) error {
+	lits, n, err := z.readLiterals(block)
+	if err != nil {
+		return err
+	}
+	if err := z.readSequences(block[n:]); err != nil {
+		return err
+	}
+	for _, s := range z.seqs {
+		if int(s.literals) > len(lits) {
+			return corrupt("sequence")
+		}
+		z.hist = append(z.hist, lits[:s.literals]...)
+		lits = lits[s.literals:]
+		if s.offset == 0 || int(s.offset) > len(z.hist) {
+			return corrupt("sequence offset")
+		}
+		from, n := len(z.hist)-int(s.offset), int(s.match)
+		if n > maxBlockSize {
+			return corrupt("sequence")
+		}
+		/*
+		   A match may overlap what it copies, repeating the offset's
+		   bytes, so copy as much of them as has been written so far.
+		*/
+		for n > 0 {
+			c := min(n, len(z.hist)-from)
+			z.hist = append(z.hist, z.hist[from:from+c]...)
+			n -= c
+		}
+	}
+	z.hist = append(z.hist, lits...)
+	return nil
+}
+
+// readLiterals reads the literals section of a compressed block,
+// returning the literals and the bytes the section took.
+func (z *Reader) readLiterals(block []byte) ([]byte, int, error) {
+	if len(block) == 0 {
+		return nil, 0, corrupt("literals")
+	}
+	kind, format := block[0]&3, block[0]>>2&3
+	if kind < 2 {
+		var size, n int
+		switch format {
+		case 0, 2:
+			size, n = int(block[0]>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return nil, 0, corrupt("literals")
+			}
+			size, n = int(block[0]>>4)|int(block[1])<<4, 2
+		case 3:
+			if len(block) < 3 {
+				return nil, 0, corrupt("literals")
+			}
+			size, n = int(block[0]>>4)|int(block[1])<<4|int(block[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return nil, 0, corrupt("literals")
+		}
+		if kind == 0 {
+			if len(block) < n+size {
+				return nil, 0, corrupt("literals")
+			}
+			return block[n : n+size], n + size, nil
+		}
+		if len(block) < n+1 {
+			return nil, 0, corrupt("literals")
+		}
+		z.lits = z.lits[:0]
+		for i := 0; i < size; i++ {
+			z.lits = append(z.lits, block[n])
+		}
+		return z.lits, n + 1, nil
+	}
+
+	streams, n := 4, [4]int{3, 3, 4, 5}[format]
+	if format == 0 {
+		streams = 1
+	}
+	if len(block) < n {
+		return nil, 0, corrupt("literals")
+	}
+	var header uint64
+	for i := n - 1; i >= 0; i-- {
+		header = header<<8 | uint64(block[i])
+	}
+	sizeBits := [4]uint{10, 10, 14, 18}[format]
+	size := int(header >> 4 & (1<<sizeBits - 1))
+	compressed := int(header >> (4 + sizeBits) & (1<<sizeBits - 1))
+	if size > maxBlockSize || len(block) < n+compressed {
+		return nil, 0, corrupt("literals")
+	}
+	data := block[n : n+compressed]
+	if kind == 2 {
+		t, used, err := readHuffmanTable(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		z.huff = t
+		data = data[used:]
+	} else if z.huff == nil {
+		return nil, 0, corrupt("literals")
+	}
+	if cap(z.lits) < size {
+		z.lits = make([]byte, size)
+	}
+	z.lits = z.lits[:size]
+	if streams == 1 {
+		if err := z.huff.decode(data, z.lits); err != nil {
+			return nil, 0, err
+		}
+		return z.lits, n + compressed, nil
+	}
+	if len(data) < 6 {
+		return nil, 0, corrupt("literals")
+	}
+	segment := (size + 3) / 4
+	if 3*segment > size {
+		return nil, 0, corrupt("literals")
+	}
+	jump, data := data[:6], data[6:]
+	for i := 0; i < 4; i++ {
+		end := len(data)
+		if i < 3 {
+			end = int(binary.LittleEndian.Uint16(jump[2*i:]))
+		}
+		out := z.lits[i*segment:]
+		if i < 3 {
+			out = out[:segment]
+		}
+		if end > len(data) {
+			return nil, 0, corrupt("literals")
+		}
+		if err := z.huff.decode(data[:end], out); err != nil {
+			return nil, 0, err
+		}
+		data = data[end:]
+	}
+	return z.lits, n + compressed, nil
+}
+
+// readSequences decodes the sequences section of a compressed block
+// into z.seqs, resolving repeated offsets as it goes.
+func (z *Reader) readSequences(data []byte) error {
+	z.seqs = z.seqs[:0]
+	if len(data) == 0 {
+		return corrupt("sequences")
+	}
+	count, n := int(data[0]), 1
+	switch {
+	case count == 0:
+		if len(data) != 1 {
+			return corrupt("sequences")
+		}
+		return nil
+	case count == 255:
+		if len(data) < 3 {
+			return corrupt("sequences")
+		}
+		count, n = int(data[1])+int(data[2])<<8+0x7F00, 3
+	case count >= 128:
+		if len(data) < 2 {
+			return corrupt("sequences")
+		}
+		count, n = (count-128)<<8+int(data[1]), 2
+	}
+	if len(data) < n+1 || data[n]&3 != 0 {
+		return corrupt("sequences")
+	}
+	modes := data[n]
+	n++
+	kinds := [3]struct {
+		predefined *fseTable
+		maxCode    int
+		maxLog     uint
+	}{
+		{literalLengthTable, maxLiteralLengthCode, maxLiteralLengthLog},
+		{offsetTable, maxOffsetCode, maxOffsetLog},
+		{matchLengthTable, maxMatchLengthCode, maxMatchLengthLog},
+	}
+	for i, k := range kinds {
+		switch modes >> (6 - 2*uint(i)) & 3 {
+		case 0:
+			z.tables[i] = k.predefined
+		case 1:
+			if len(data) <= n || int(data[n]) > k.maxCode {
+				return corrupt("sequences")
+			}
+			z.tables[i] = rleTable(data[n])
+			n++
+		case 2:
+			norm, log, used, err := readDistribution(data[n:], k.maxCode, k.maxLog)
+			if err != nil {
+				return err
+			}
+			if z.tables[i], err = newFSETable(norm, log); err != nil {
+				return err
+			}
+			n += used
+		case 3:
+			if z.tables[i] == nil {
+				return corrupt("sequences")
+			}
+		}
+	}
+	r, err := newReverseBitReader(data[n:])
+	if err != nil {
+		return err
+	}
+	ll, of, ml := z.tables[0], z.tables[1], z.tables[2]
+	llState, ofState, mlState := ll.init(r), of.init(r), ml.init(r)
+	for i := 0; i < count; i++ {
+		code := of.entries[ofState].sym
+		offset := uint32(1)<<code + uint32(r.read(uint(code)))
+		match, extra := matchLength(ml.entries[mlState].sym)
+		match += uint32(r.read(extra))
+		literals, extra := literalLength(ll.entries[llState].sym)
+		literals += uint32(r.read(extra))
+
+		if offset > 3 {
+			offset -= 3
+			z.repeats = [3]uint32{offset, z.repeats[0], z.repeats[1]}
+		} else {
+			/* Without literals the repeats shift along by one. */
+			if literals == 0 {
+				offset++
+			}
+			switch offset {
+			case 1:
+				offset = z.repeats[0]
+			case 2:
+				offset = z.repeats[1]
+				z.repeats = [3]uint32{offset, z.repeats[0], z.repeats[2]}
+			case 3:
+				offset = z.repeats[2]
+				z.repeats = [3]uint32{offset, z.repeats[0], z.repeats[1]}
+			case 4:
+				offset = z.repeats[0] - 1
+				z.repeats = [3]uint32{offset, z.repeats[0], z.repeats[1]}
+			}
+		}
+		z.seqs = append(z.seqs, sequence{literals, match, offset})
+
+		if i < count-1 {
+			llState = ll.next(r, llState)
+			mlState = ml.next(r, mlState)
+			ofState = of.next(r, ofState)
+		}
+		if r.overflowed() {
+			return corrupt("sequences")
+		}
+	}
+	if !r.finished() {
+		return corrupt("sequences")
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	minMatch = 4
+	hashLog  = 15
+
+	/* A 128KB window, which every match fits in since they stay in their block. */
+	windowDescriptor = 7 << 3
+	/* The frame has a checksum and a window, its size is not known up front. */
+	frameDescriptor = 0x04
+)
+
+// Writer compresses what is written to it into a single zstd frame,
+// which is complete once the Writer is closed.
+type Writer struct {
+	w      io.Writer
+	err    error
+	header bool
+	closed bool
+	hash   xxhash64
+
+	buf   []byte
+	out   []byte
+	lits  []byte
+	seqs  []sequence
+	table []int32
+}
+
+// NewWriter returns a Writer compressing into w.
+func NewWriter(w io.Writer) *Writer {
+	z := &Writer{w: w, table: make([]int32, 1<<hashLog)}
+	z.hash.reset()
+	return z
+}
+
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("Write to a closed zstd Writer.")
+	}
+	written := 0
+	for len(p) > 0 {
+		/* A full block is only written once there is more to come. */
+		if len(z.buf) == maxBlockSize {
+			if err := z.writeBlock(false); err != nil {
+				return written, err
+			}
+		}
+		n := min(len(p), maxBlockSize-len(z.buf))
+		z.buf = append(z.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the last block and the checksum. It does not close the
+// underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if err := z.writeBlock(true); err != nil {
+		return err
+	}
+	z.closed = true
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], uint32(z.hash.Sum64()))
+	_, z.err = z.w.Write(sum[:])
+	return z.err
+}
+
+func (z *Writer) writeBlock(last bool) error {
+	if z.err != nil {
+		return z.err
+	}
+	z.out = z.out[:0]
+	if !z.header {
+		z.out = binary.LittleEndian.AppendUint32(z.out, frameMagic)
+		z.out = append(z.out, frameDescriptor, windowDescriptor)
+		z.header = true
+	}
+	z.hash.Write(z.buf)
+	z.out = z.appendBlock(z.out, z.buf, last)
+	z.buf = z.buf[:0]
+	_, z.err = z.w.Write(z.out)
+	return z.err
+}
+
+func appendBlockHeader(dst []byte, kind, size int, last bool) []byte {
+	h := uint32(size)<<3 | uint32(kind)<<1
+	if last {
+		h |= 1
+	}
+	return append(dst, byte(h), byte(h>>8), byte(h>>16))
+}
+
+// appendBlock appends src as a block, compressed if that makes it
+// smaller.
+func (z *Writer) appendBlock(dst, src []byte, last bool) []byte {
+	if len(src) > 0 && isRun(src) {
+		dst = appendBlockHeader(dst, blockRLE, len(src), last)
+		return append(dst, src[0])
+	}
+	/* The header is written once the size is known. */
+	start := len(dst)
+	dst = z.compress(appendBlockHeader(dst, blockCompressed, 0, last), src)
+	if n := len(dst) - start - 3; len(z.seqs) > 0 && n < len(src) {
+		appendBlockHeader(dst[:start], blockCompressed, n, last)
+		return dst
+	}
+	dst = appendBlockHeader(dst[:start], blockRaw, len(src), last)
+	return append(dst, src...)
+}
+
+func isRun(b []byte) bool {
+	for _, c := range b[1:] {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func load32(b []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(b[i:])
+}
+
+func hash4(u uint32) uint32 {
+	return u * 2654435761 >> (32 - hashLog)
+}
+
+// findSequences splits src into literals and matches with earlier
+// parts of src, taking the first match a hash of 4 bytes finds.
+func (z *Writer) findSequences(src []byte) {
+	z.lits, z.seqs = z.lits[:0], z.seqs[:0]
+	for i := range z.table {
+		z.table[i] = 0
+	}
+	/* The table holds positions plus one, leaving zero for empty. */
+	lit, i := 0, 0
+	for i+minMatch <= len(src) {
+		cur := load32(src, i)
+		h := hash4(cur)
+		candidate := int(z.table[h]) - 1
+		z.table[h] = int32(i + 1)
+		if candidate < 0 || load32(src, candidate) != cur {
+			/* Step further the longer nothing has matched. */
+			i += 1 + (i-lit)>>6
+			continue
+		}
+		n := minMatch
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		for i > lit && candidate > 0 && src[i-1] == src[candidate-1] {
+			i--
+			candidate--
+			n++
+		}
+		z.lits = append(z.lits, src[lit:i]...)
+		z.seqs = append(z.seqs, sequence{uint32(i - lit), uint32(n), uint32(i - candidate)})
+		i += n
+		lit = i
+		if i+minMatch <= len(src) {
+			z.table[hash4(load32(src, i-2))] = int32(i - 1)
+		}
+	}
+	z.lits = append(z.lits, src[lit:]...)
+}
+
+// compress appends the contents of a compressed block for src: the
+// literals uncompressed, then the sequences coded with the predefined
+// tables.
+func (z *Writer) compress(dst, src []byte) []byte {
+	z.findSequences(src)
+	if len(z.seqs) == 0 {
+		return dst
+	}
+	switch n := len(z.lits); {
+	case n < 1<<5:
+		dst = append(dst, byte(n<<3))
+	case n < 1<<12:
+		dst = append(dst, byte(n<<4|1<<2), byte(n>>4))
+	default:
+		dst = append(dst, byte(n<<4|3<<2), byte(n>>4), byte(n>>12))
+	}
+	dst = append(dst, z.lits...)
+
+	switch n := len(z.seqs); {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8+128), byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	/* Every table is the predefined one. */
+	dst = append(dst, 0)
+
+	w := &bitWriter{out: dst}
+	ll := fseState{enc: literalLengthEncoder}
+	of := fseState{enc: offsetEncoder}
+	ml := fseState{enc: matchLengthEncoder}
+	/* The sequences are written last first, to be read first first. */
+	for i := len(z.seqs) - 1; i >= 0; i-- {
+		s := z.seqs[i]
+		llCode, llExtra, llBits := literalLengthCode(s.literals)
+		mlCode, mlExtra, mlBits := matchLengthCode(s.match)
+		ofCode, ofExtra, ofBits := offsetCode(s.offset)
+		if i == len(z.seqs)-1 {
+			ml.init(mlCode)
+			of.init(ofCode)
+			ll.init(llCode)
+		} else {
+			of.encode(w, ofCode)
+			ml.encode(w, mlCode)
+			ll.encode(w, llCode)
+		}
+		w.add(uint64(llExtra), llBits)
+		w.add(uint64(mlExtra), mlBits)
+		w.add(uint64(ofExtra), ofBits)
+	}
+	ml.flush(w)
+	of.flush(w)
+	ll.flush(w)
+	return w.close()
+}
+
+/* The codes for a sequence's values, with the extra bits to add to their baseline. */
+
+func literalLengthCode(n uint32) (uint8, uint32, uint) {
+	if n < 16 {
+		return uint8(n), 0, 0
+	}
+	code := len(literalLengthBase) - 1
+	for literalLengthBase[code] > n {
+		code--
+	}
+	return uint8(code + 16), n - literalLengthBase[code], uint(literalLengthBits[code])
+}
+
+func matchLengthCode(n uint32) (uint8, uint32, uint) {
+	if n < 35 {
+		return uint8(n - 3), 0, 0
+	}
+	code := len(matchLengthBase) - 1
+	for matchLengthBase[code] > n {
+		code--
+	}
+	return uint8(code + 32), n - matchLengthBase[code], uint(matchLengthBits[code])
+}
+
+/* Offsets are sent 3 more than they are, below that are the repeats. */
+func offsetCode(offset uint32) (uint8, uint32, uint) {
+	v := offset + 3
+	code := uint(bits.Len32(v) - 1)
+	return uint8(code), v - 1<<code, code
+}
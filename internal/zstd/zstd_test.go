@@ -0,0 +1,176 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// testInput is text with enough repetition to compress, spanning
+// two blocks. The files in testdata are it compressed by the reference
+// zstd, with -3, with -19 and with --fast=5 --no-check.
+func testInput() []byte {
+	words := strings.Fields("the object store keeps containers of escaped names")
+	b := &bytes.Buffer{}
+	seed := uint32(1)
+	for b.Len() < maxBlockSize+10000 {
+		seed = seed*1664525 + 1013904223
+		b.WriteString(words[seed>>16%uint32(len(words))])
+		if seed&0x700 == 0 {
+			b.WriteString(".\n")
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.Bytes()
+}
+
+func compress(t *testing.T, chunks ...[]byte) []byte {
+	b := &bytes.Buffer{}
+	w := NewWriter(b)
+	for _, c := range chunks {
+		if _, err := w.Write(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func decompress(b []byte) ([]byte, error) {
+	return ioutil.ReadAll(NewReader(bytes.NewReader(b)))
+}
+
+func TestRoundTrip(t *testing.T) {
+	text := testInput()
+	noise := make([]byte, 200000)
+	seed := uint32(7)
+	for i := range noise {
+		seed = seed*1664525 + 1013904223
+		noise[i] = byte(seed >> 24)
+	}
+	for name, chunks := range map[string][][]byte{
+		"empty":      nil,
+		"short":      {[]byte("abc")},
+		"text":       {text},
+		"text split": {text[:1], text[1:maxBlockSize], text[maxBlockSize : maxBlockSize+5], text[maxBlockSize+5:]},
+		"noise":      {noise},
+		"run":        {bytes.Repeat([]byte{'x'}, 3*maxBlockSize+1)},
+		"mixed":      {text[:50000], noise[:50000], text[:50000]},
+	} {
+		compressed := compress(t, chunks...)
+		plain, err := decompress(compressed)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !bytes.Equal(plain, bytes.Join(chunks, nil)) {
+			t.Errorf("%s: decompressed contents do not match.", name)
+		}
+	}
+	if n := len(compress(t, text)); n > len(text)/2 {
+		t.Errorf("Text only compressed from %d to %d bytes.", len(text), n)
+	}
+}
+
+func TestReadReference(t *testing.T) {
+	text := testInput()
+	reference := map[string][]byte{}
+	for _, name := range []string{"level-3", "level-19", "fast"} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", name+".zst"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reference[name] = b
+	}
+	skippable := []byte{0x50, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'a', 'b', 'c'}
+	reference["concatenated"] = bytes.Join([][]byte{reference["level-19"], skippable, reference["fast"]}, nil)
+	for name, compressed := range reference {
+		expected := text
+		if name == "concatenated" {
+			expected = bytes.Repeat(text, 2)
+		}
+		/* Read a byte at a time to stop part way through blocks. */
+		plain, err := ioutil.ReadAll(iotest.OneByteReader(NewReader(bytes.NewReader(compressed))))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !bytes.Equal(plain, expected) {
+			t.Errorf("%s: decompressed contents do not match.", name)
+		}
+	}
+}
+
+func TestReadCorrupt(t *testing.T) {
+	compressed := compress(t, testInput())
+	bad := append([]byte{}, compressed...)
+	bad[len(bad)-1] ^= 1
+	if _, err := decompress(bad); err == nil {
+		t.Error("A wrong checksum was accepted.")
+	}
+	if _, err := decompress(compressed[:len(compressed)/2]); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected a truncated frame to fail with ErrUnexpectedEOF, got: %v", err)
+	}
+	if _, err := decompress([]byte("not compressed at all")); err == nil {
+		t.Error("Input which is not zstd was accepted.")
+	}
+	if _, err := decompress(nil); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected empty input to fail with ErrUnexpectedEOF, got: %v", err)
+	}
+}
+
+func TestXXHash(t *testing.T) {
+	for data, expected := range map[string]uint64{
+		"":             0xef46db3751d8e999,
+		"hello, world": 0xb33a384e6d1b1242,
+		"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$": 0x1032d841e824f998,
+	} {
+		var h xxhash64
+		h.reset()
+		/* Write a byte at a time to go through the buffering. */
+		for i := range data {
+			h.Write([]byte{data[i]})
+		}
+		if sum := h.Sum64(); sum != expected {
+			t.Errorf("%q: expected %x, got %x", data, expected, sum)
+		}
+	}
+}
@@ -80,6 +80,17 @@ func (h HashedFile) Hash() string {
 	return fmt.Sprintf("%x", h.MD5.Sum(nil))
 }
 
+/*
+  NewHashedFile hashes contents which are already in memory and returns
+  a HashedFile ready to be read from.
+*/
+func NewHashedFile(contents []byte) *HashedFile {
+	hf := &HashedFile{MD5: md5.New()}
+	hf.Write(contents)
+	hf.FileContents = bytes.NewReader(hf.filecontents)
+	return hf
+}
+
 /*
   Helper function to open, hash and return an io.ReadWriter of the
   file.
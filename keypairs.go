@@ -0,0 +1,172 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// Keypair is an SSH keypair known to the compute API, which servers
+// are given with Server.Key. The PrivateKey is only returned when the
+// keypair is generated by GenerateKeypair.
+type Keypair struct {
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key,omitempty"`
+	PrivateKey  string `json:"private_key,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	UserID      string `json:"user_id,omitempty"`
+}
+
+// PublicKey is an SSH public key, golang.org/x/crypto/ssh.PublicKey
+// satisfies it so those keys can be imported directly.
+type PublicKey interface {
+	// Type is the key's algorithm, such as ssh-rsa.
+	Type() string
+	// Marshal returns the key in the SSH wire format.
+	Marshal() []byte
+}
+
+// AuthorizedKey formats the key as it appears in an authorized_keys
+// file, which is how the compute API takes public keys.
+func AuthorizedKey(key PublicKey) string {
+	return key.Type() + " " + base64.StdEncoding.EncodeToString(key.Marshal())
+}
+
+// Fingerprint returns the MD5 fingerprint of a public key in the
+// authorized_keys format, as the compute API reports it.
+func Fingerprint(publicKey string) (string, error) {
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return "", errors.New("A public key has its type and the key itself.")
+	}
+	wire, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum(wire)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ":"), nil
+}
+
+func (a Access) createKeypair(k Keypair) (*Keypair, error) {
+	if k.Name == "" {
+		return nil, errors.New("A keypair name is required.")
+	}
+	b, err := json.Marshal(map[string]Keypair{"keypair": k})
+	if err != nil {
+		return nil, err
+	}
+	body, err := a.baseComputeRequest("os-keypairs", "POST", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		K Keypair `json:"keypair"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.K, nil
+}
+
+// GenerateKeypair has the compute API generate a new keypair, the
+// returned keypair is the only place the private key is available.
+func (a Access) GenerateKeypair(name string) (*Keypair, error) {
+	return a.createKeypair(Keypair{Name: name})
+}
+
+// ImportKeypair imports an existing public key, in the authorized_keys
+// format, under the name given.
+func (a Access) ImportKeypair(name, publicKey string) (*Keypair, error) {
+	if _, err := Fingerprint(publicKey); err != nil {
+		return nil, err
+	}
+	return a.createKeypair(Keypair{Name: name, PublicKey: strings.TrimSpace(publicKey)})
+}
+
+// ImportKeypairFile imports the public key in the file, such as
+// ~/.ssh/id_rsa.pub.
+func (a Access) ImportKeypairFile(name, filename string) (*Keypair, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return a.ImportKeypair(name, string(contents))
+}
+
+// ImportPublicKey imports a public key, such as an ssh.PublicKey.
+func (a Access) ImportPublicKey(name string, key PublicKey) (*Keypair, error) {
+	return a.ImportKeypair(name, AuthorizedKey(key))
+}
+
+// ListKeypairs lists the keypairs of the account.
+func (a Access) ListKeypairs() ([]Keypair, error) {
+	body, err := a.baseComputeRequest("os-keypairs", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		K []struct {
+			K Keypair `json:"keypair"`
+		} `json:"keypairs"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	keypairs := make([]Keypair, len(o.K))
+	for i, k := range o.K {
+		keypairs[i] = k.K
+	}
+	return keypairs, nil
+}
+
+// DeleteKeypair deletes the keypair with the name given.
+func (a Access) DeleteKeypair(name string) error {
+	_, err := a.baseComputeRequest(
+		fmt.Sprintf("os-keypairs/%s", url.PathEscape(name)), "DELETE", nil,
+	)
+	return err
+}
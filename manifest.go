@@ -0,0 +1,216 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// ManifestEntry records the state of a single object when the manifest
+// was generated.
+type ManifestEntry struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	Etag   string `json:"etag"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest is a signed record of the objects in a container, which can
+// later be used to prove that none of them have changed.
+type Manifest struct {
+	Container string          `json:"container"`
+	Prefix    string          `json:"prefix"`
+	Created   time.Time       `json:"created"`
+	Objects   []ManifestEntry `json:"objects"`
+	Signature string          `json:"signature"`
+}
+
+// ManifestReport lists the names of the objects which differ from a
+// manifest.
+type ManifestReport struct {
+	// Missing objects are in the manifest but not the container.
+	Missing []string
+	// Altered objects have a different size, Etag or SHA-256.
+	Altered []string
+	// Extra objects are in the container but not the manifest.
+	Extra []string
+}
+
+// OK reports whether the container matched the manifest exactly.
+func (r ManifestReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Altered) == 0 && len(r.Extra) == 0
+}
+
+// sha256Object downloads an object and returns the SHA-256 of its
+// contents.
+func (a Access) sha256Object(container, name string) (string, error) {
+	contents, _, err := a.ObjectStoreDownload(container + "/" + name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// GenerateManifest lists every object in the container beginning with
+// prefix and downloads each of them to record its SHA-256.
+//
+// The manifest is unsigned, call Sign before writing it out.
+func (a Access) GenerateManifest(container, prefix string) (*Manifest, error) {
+	list, err := a.ListAllObjects(container, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		Container: container,
+		Prefix:    prefix,
+		Created:   time.Now().UTC(),
+		Objects:   make([]ManifestEntry, 0, len(list)),
+	}
+	for _, f := range list {
+		sum, err := a.sha256Object(container, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		m.Objects = append(m.Objects, ManifestEntry{
+			Name:   f.Name,
+			Bytes:  f.Bytes,
+			Etag:   f.Hash,
+			SHA256: sum,
+		})
+	}
+	return m, nil
+}
+
+// signature is the HMAC-SHA256 of the manifest's JSON encoding with the
+// signature itself left blank.
+func (m Manifest) signature(key []byte) (string, error) {
+	m.Signature = ""
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sign signs the manifest with the key.
+func (m *Manifest) Sign(key []byte) error {
+	sig, err := m.signature(key)
+	if err != nil {
+		return err
+	}
+	m.Signature = sig
+	return nil
+}
+
+// CheckSignature returns an error if the manifest was not signed with
+// the key, or has been changed since it was.
+func (m *Manifest) CheckSignature(key []byte) error {
+	sig, err := m.signature(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(m.Signature)) {
+		return errors.New("Manifest signature is invalid.")
+	}
+	return nil
+}
+
+// WriteManifest writes the manifest as JSON.
+func WriteManifest(w io.Writer, m *Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// ReadManifest reads a manifest written by WriteManifest, checking
+// that it was signed with the key.
+func ReadManifest(r io.Reader, key []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, err
+	}
+	if err := m.CheckSignature(key); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// VerifyManifest re-lists the manifest's container and compares it to
+// the manifest. The size and Etag of each object are always compared,
+// when rehash is true every object is also downloaded and its SHA-256
+// checked.
+func (a Access) VerifyManifest(m *Manifest, rehash bool) (*ManifestReport, error) {
+	list, err := a.ListAllObjects(m.Container, m.Prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]File, len(list))
+	for _, f := range list {
+		current[f.Name] = f
+	}
+	report := &ManifestReport{}
+	for _, entry := range m.Objects {
+		f, ok := current[entry.Name]
+		if !ok {
+			report.Missing = append(report.Missing, entry.Name)
+			continue
+		}
+		delete(current, entry.Name)
+		if f.Bytes != entry.Bytes || f.Hash != entry.Etag {
+			report.Altered = append(report.Altered, entry.Name)
+			continue
+		}
+		if rehash {
+			sum, err := a.sha256Object(m.Container, entry.Name)
+			if err != nil {
+				return nil, err
+			}
+			if sum != entry.SHA256 {
+				report.Altered = append(report.Altered, entry.Name)
+			}
+		}
+	}
+	for name := range current {
+		report.Extra = append(report.Extra, name)
+	}
+	sort.Strings(report.Extra)
+	return report, nil
+}
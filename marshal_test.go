@@ -0,0 +1,191 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden request bodies in testdata")
+
+// checkGolden compares the request body v encodes to, indented, with
+// the golden file testdata/name.json.
+func checkGolden(t *testing.T, name string, v json.Marshaler) {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	compareGolden(t, name, b)
+}
+
+// compareGolden is checkGolden for a request body which has already
+// been encoded, such as one captured from a request.
+func compareGolden(t *testing.T, name string, b []byte) {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, b, "", "  "); err != nil {
+		t.Fatalf("%s: invalid JSON %s: %s", name, b, err)
+	}
+	indented.WriteString("\n")
+	golden := filepath.Join("testdata", name+".json")
+	if *update {
+		if err := ioutil.WriteFile(golden, indented.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(indented.Bytes(), expected) {
+		t.Errorf("%s: expected\n%s\ngot\n%s", name, expected, indented.Bytes())
+	}
+}
+
+func TestRequestBodies(t *testing.T) {
+	metadata := map[string]string{"role": `"web"`, "owner": "ops\\team"}
+	checkGolden(t, "server", Server{
+		FlavorRef:      Small,
+		ImageRef:       UbuntuPrecise12_04,
+		Name:           `My "quoted" server`,
+		Key:            "deploy",
+		MinCount:       1,
+		MaxCount:       2,
+		UserData:       "#!/bin/sh\necho hello\n",
+		Metadata:       metadata,
+		SecurityGroups: []SecurityGroup{{Name: "default"}, {Name: "web"}},
+		Personality: Personality{
+			{Path: "/etc/motd", Contents: []byte("Welcome to \"web\"\n")},
+		},
+	})
+	checkGolden(t, "server_minimal", Server{
+		FlavorRef: XSmall,
+		ImageRef:  "8c096c29-a666-4b82-99c4-c77dc70cfb40",
+		Name:      "minimal",
+	})
+	checkGolden(t, "create_image", createImageRequest{
+		C: imageRequest{Name: "1032975", Metadata: &metadata},
+	})
+	checkGolden(t, "volume", Volume{
+		Size:        10,
+		DisplayName: `data "a"`,
+		Metadata:    metadata,
+	})
+	checkGolden(t, "database", DatabaseReq{Instance: Database{
+		Name: "orders", FlavorRef: "https://region-a.geo-1.rdb.hpcloudsvc.com/v1.0/1/flavors/1",
+	}})
+	checkGolden(t, "db_security_rule", DBSecRuleReq{
+		SecurityGroupID: "sg-1", Cidr: "10.0.0.0/8", FromPort: 3306, ToPort: 3306,
+	})
+}
+
+func TestRebuildRequestBody(t *testing.T) {
+	var body []byte
+	requests := 0
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		body, _ = ioutil.ReadAll(req.Body)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"server": {"id": 1032975, "status": "REBUILD"}}`))
+	})
+	r := Rebuild{
+		ImageRef: UbuntuPrecise12_04,
+		Name:     "web",
+		Metadata: map[string]string{"role": "web"},
+	}
+	if err := r.Personality.AddBytes("/etc/motd", []byte("Welcome to \"web\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := test_account.RebuildServer("1032975", r); err != nil {
+		t.Fatal(err)
+	}
+	compareGolden(t, "rebuild", body)
+
+	requests = 0
+	tooMany := r
+	for i := len(r.Personality); i <= MaxPersonalityFiles; i++ {
+		tooMany.Personality = append(tooMany.Personality, PersonalityFile{Path: fmt.Sprintf("/etc/file-%d", i)})
+	}
+	tooLarge := r
+	tooLarge.Personality = Personality{{Path: "/etc/big", Contents: make([]byte, MaxPersonalityFileSize+1)}}
+	for name, r := range map[string]Rebuild{"too many files": tooMany, "too large a file": tooLarge} {
+		if _, err := test_account.RebuildServer("1032975", r); err == nil {
+			t.Errorf("Expected a rebuild with %s to fail.", name)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("Invalid rebuilds made %d requests.", requests)
+	}
+}
+
+func TestRequestBodyValidation(t *testing.T) {
+	long := string(make([]byte, 256))
+	invalid := map[string]json.Marshaler{
+		"volume without a size":  Volume{DisplayName: "data"},
+		"database without name":  DatabaseReq{Instance: Database{FlavorRef: "1"}},
+		"rule without a CIDR":    DBSecRuleReq{SecurityGroupID: "sg-1", FromPort: 1, ToPort: 1},
+		"image with long values": createImageRequest{C: imageRequest{Metadata: &map[string]string{"k": long}}},
+	}
+	for name, v := range invalid {
+		if _, err := v.MarshalJSON(); err == nil {
+			t.Errorf("Expected the %s to fail.", name)
+		}
+	}
+}
+
+func TestPersonalityLimits(t *testing.T) {
+	p := Personality{}
+	for i := 0; i < MaxPersonalityFiles; i++ {
+		if err := p.AddBytes(fmt.Sprintf("/etc/file-%d", i), []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.AddBytes("/etc/one-too-many", nil); err == nil {
+		t.Error("Expected more than the maximum files to fail.")
+	}
+	p = Personality{}
+	if err := p.AddBytes("etc/motd", nil); err == nil {
+		t.Error("Expected a relative path to fail.")
+	}
+	if err := p.AddBytes("/etc/big", make([]byte, MaxPersonalityFileSize+1)); err == nil {
+		t.Error("Expected a file over the size limit to fail.")
+	}
+	if err := p.AddFile("testfile.png", "/srv/testfile.png"); err != nil || len(p) != 1 {
+		t.Errorf("Expected testfile.png to be added: %v", err)
+	}
+}
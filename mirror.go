@@ -0,0 +1,287 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mirroredHeaders are copied along with an object's contents, as well
+// as all of its X-Object-Meta- headers.
+var mirroredHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	"Content-Disposition",
+	"X-Delete-At",
+}
+
+// Mirror copies the objects in one container into another, which may
+// belong to another tenant or be in another region by giving the
+// Accesses different ObjectStore endpoints.
+//
+// Only objects which are missing from the destination, or whose Etag
+// or content type differs, are copied, so running it again only copies
+// what has changed since. A change to only an object's metadata leaves
+// its Etag alone, so such changes are missed unless CompareMetadata is
+// set. Objects are streamed from the source to the
+// destination without being held in memory, along with their metadata
+// and content type. Segmented objects are copied as a single object,
+// and since the Etag of their manifest never matches that, they are
+// copied on every run.
+type Mirror struct {
+	Source               Access
+	SourceContainer      string
+	Destination          Access
+	DestinationContainer string
+	// Prefix limits the mirror to objects beginning with it.
+	Prefix string
+	// Concurrency is the number of objects copied at once, it
+	// defaults to 4.
+	Concurrency int
+	// Delete removes objects from the destination which are no
+	// longer in the source.
+	Delete bool
+	// CompareMetadata checks the mirrored headers and metadata of
+	// objects whose Etags match, copying them again if they differ.
+	// This costs a HEAD request on each side for every such object.
+	CompareMetadata bool
+}
+
+// MirrorResult lists what a single run of a Mirror did.
+type MirrorResult struct {
+	Copied  []string
+	Skipped []string
+	Deleted []string
+	// Failed holds the error for every object which could not be
+	// copied or deleted.
+	Failed map[string]error
+}
+
+// Run mirrors the containers once. The returned error is only for
+// failures which stopped the run entirely, errors with individual
+// objects are in the result's Failed map.
+func (m Mirror) Run() (*MirrorResult, error) {
+	if err := m.Destination.CreateContainer(m.DestinationContainer); err != nil {
+		return nil, err
+	}
+	src, err := m.Source.ListAllObjects(m.SourceContainer, m.Prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := m.Destination.ListAllObjects(m.DestinationContainer, m.Prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]File, len(dst))
+	for _, f := range dst {
+		existing[f.Name] = f
+	}
+
+	result := &MirrorResult{Failed: map[string]error{}}
+	type job struct {
+		name   string
+		delete bool
+		// check is set when only the metadata may have changed.
+		check bool
+	}
+	jobs := make(chan job)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	concurrency := m.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				var err error
+				same := false
+				switch {
+				case j.delete:
+					err = m.Destination.ObjectStoreDelete(m.DestinationContainer + "/" + j.name)
+				case j.check:
+					if same, err = m.sameMetadata(j.name); err == nil && !same {
+						err = m.copyObject(j.name)
+					}
+				default:
+					err = m.copyObject(j.name)
+				}
+				mu.Lock()
+				switch {
+				case err != nil:
+					result.Failed[j.name] = err
+				case same:
+					result.Skipped = append(result.Skipped, j.name)
+				case j.delete:
+					result.Deleted = append(result.Deleted, j.name)
+				default:
+					result.Copied = append(result.Copied, j.name)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, f := range src {
+		d, ok := existing[f.Name]
+		delete(existing, f.Name)
+		if ok && d.Hash == f.Hash && d.ContentType == f.ContentType {
+			if m.CompareMetadata {
+				jobs <- job{name: f.Name, check: true}
+				continue
+			}
+			mu.Lock()
+			result.Skipped = append(result.Skipped, f.Name)
+			mu.Unlock()
+			continue
+		}
+		jobs <- job{name: f.Name}
+	}
+	if m.Delete {
+		for name := range existing {
+			jobs <- job{name: name, delete: true}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return result, nil
+}
+
+// copyObject streams a single object from the source into the
+// destination. The source's Etag is sent with the upload so the
+// destination checks the contents arrived intact.
+func (m Mirror) copyObject(name string) error {
+	object := escapeObjectName(name)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s/%s", m.Source.objectStoreURL(), escapeObjectName(m.SourceContainer), object), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("X-Auth-Token", m.Source.AuthToken())
+	req.Header.Add("Accept-Encoding", "identity")
+	resp, err := m.Source.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return StatusError{http.StatusOK, resp.StatusCode}
+	}
+
+	put, err := http.NewRequest("PUT", fmt.Sprintf("%s/%s/%s", m.Destination.objectStoreURL(), escapeObjectName(m.DestinationContainer), object), resp.Body)
+	if err != nil {
+		return err
+	}
+	put.ContentLength = resp.ContentLength
+	for _, key := range mirroredHeaders {
+		if v := resp.Header.Get(key); v != "" {
+			put.Header.Set(key, v)
+		}
+	}
+	for key, value := range resp.Header {
+		if strings.HasPrefix(key, "X-Object-Meta-") {
+			put.Header[key] = value
+		}
+	}
+	/* A segmented object's Etag is not the hash of its contents. */
+	etag := resp.Header.Get("Etag")
+	if resp.Header.Get("X-Object-Manifest") != "" {
+		etag = ""
+	}
+	if etag != "" {
+		put.Header.Set("Etag", etag)
+	}
+	put.Header.Add("X-Auth-Token", m.Destination.AuthToken())
+	presp, err := m.Destination.Client.Do(put)
+	if err != nil {
+		return err
+	}
+	defer presp.Body.Close()
+	if presp.StatusCode != http.StatusCreated {
+		return StatusError{http.StatusCreated, presp.StatusCode}
+	}
+	if etag != "" && presp.Header.Get("Etag") != etag {
+		return errors.New("MD5 hashes do not match. Integrity not guaranteed.")
+	}
+	return nil
+}
+
+// sameMetadata reports whether the object has the same mirrored
+// headers and metadata in the source and the destination.
+func (m Mirror) sameMetadata(name string) (bool, error) {
+	src, err := m.Source.ObjectStoreHead(m.SourceContainer + "/" + name)
+	if err != nil {
+		return false, err
+	}
+	dst, err := m.Destination.ObjectStoreHead(m.DestinationContainer + "/" + name)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range mirroredHeaders {
+		if src.Get(key) != dst.Get(key) {
+			return false, nil
+		}
+	}
+	for _, h := range [][2]http.Header{{src, dst}, {dst, src}} {
+		for key := range h[0] {
+			if strings.HasPrefix(key, "X-Object-Meta-") && h[0].Get(key) != h[1].Get(key) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// RunEvery runs the mirror straight away and then once every interval
+// until the context is cancelled, passing the outcome of each run to
+// report, which may be nil. Since runs only copy what has changed, this
+// keeps the destination up to date.
+func (m Mirror) RunEvery(ctx context.Context, interval time.Duration, report func(*MirrorResult, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := m.Run()
+		if report != nil {
+			report(result, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastModifiedLayout is the layout of the last_modified field in
+// object listings.
+const lastModifiedLayout = "2006-01-02T15:04:05.999999"
+
+// ListObjectsPage lists a single page of the container, only including
+// objects which begin with prefix and starting after marker. A limit of
+// zero uses the object store's own page size.
+//
+// When delimiter is non-empty the objects are rolled up into pseudo
+// directories at the first delimiter following the prefix, these are
+// returned with only their Subdir field set.
+func (a Access) ListObjectsPage(container, prefix, delimiter, marker string, limit int) (FileList, error) {
+	q := url.Values{}
+	q.Set("format", "json")
+	if prefix != "" {
+		q.Set("prefix", prefix)
+	}
+	if delimiter != "" {
+		q.Set("delimiter", delimiter)
+	}
+	if marker != "" {
+		q.Set("marker", marker)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	body, err := a.baseRequest(
+		fmt.Sprintf("%s/%s?%s", a.objectStoreURL(), escapeObjectName(container), q.Encode()),
+		"GET", nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	fl := FileList{}
+	if len(body) == 0 {
+		return fl, nil
+	}
+	if err = json.Unmarshal(body, &fl); err != nil {
+		return nil, err
+	}
+	fl.parseDates()
+	return fl, nil
+}
+
+// ListAllObjects is ListObjectsPage, following the markers until the
+// whole of the listing has been retrieved.
+func (a Access) ListAllObjects(container, prefix, delimiter string) (FileList, error) {
+	all := FileList{}
+	marker := ""
+	for {
+		page, err := a.ListObjectsPage(container, prefix, delimiter, marker, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			return all, nil
+		}
+		all = append(all, page...)
+		marker = page[len(page)-1].Marker()
+	}
+}
+
+// Marker returns the marker which continues a listing after this
+// entry. For a pseudo directory this is just past everything inside
+// it, as a marker of the directory itself would list its contents
+// again.
+func (f File) Marker() string {
+	if f.Subdir == "" {
+		return f.Name
+	}
+	last := f.Subdir[len(f.Subdir)-1]
+	return f.Subdir[:len(f.Subdir)-1] + string([]byte{last + 1})
+}
+
+func (fl FileList) parseDates() {
+	for i := range fl {
+		if fl[i].StrLastModified == "" {
+			continue
+		}
+		t, err := time.Parse(lastModifiedLayout, fl[i].StrLastModified)
+		if err == nil {
+			fl[i].LastModified = &t
+		}
+	}
+}
+
+// ContainerFS is a read-only fs.FS view of a container, or of the part
+// of a container below a prefix. Pseudo directories are formed by
+// splitting object names on "/".
+//
+// Since it implements fs.FS it can be used with http.FS, fs.WalkDir,
+// template.ParseFS and friends. Objects are downloaded in full the
+// first time they are read from.
+type ContainerFS struct {
+	access    Access
+	container string
+	prefix    string
+}
+
+// ContainerFS returns a read-only filesystem rooted at prefix inside
+// the container. An empty prefix is the root of the container.
+func (a Access) ContainerFS(container, prefix string) *ContainerFS {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &ContainerFS{access: a, container: container, prefix: prefix}
+}
+
+func (c *ContainerFS) objectName(name string) string {
+	if name == "." {
+		return c.prefix
+	}
+	return c.prefix + name
+}
+
+// lookup resolves name to either an object or a pseudo directory.
+func (c *ContainerFS) lookup(op, name string) (*objectInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &objectInfo{name: ".", dir: true}, nil
+	}
+	full := c.objectName(name)
+	marker := ""
+	for {
+		page, err := c.access.ListObjectsPage(c.container, full, "/", marker, 0)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, f := range page {
+			switch {
+			case f.Name == full:
+				return &objectInfo{name: path.Base(name), file: f}, nil
+			case f.Subdir == full+"/":
+				return &objectInfo{name: path.Base(name), dir: true, file: f}, nil
+			case f.Name > full+"/" || f.Subdir > full+"/":
+				/* The listing is sorted, so both are behind us. */
+				return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+		}
+		marker = page[len(page)-1].Marker()
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// Open implements fs.FS.
+func (c *ContainerFS) Open(name string) (fs.File, error) {
+	info, err := c.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.dir {
+		return &containerDir{fs: c, name: name, info: info}, nil
+	}
+	return &containerFile{fs: c, name: name, info: info}, nil
+}
+
+// Stat implements fs.StatFS.
+func (c *ContainerFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := c.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (c *ContainerFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	prefix := c.objectName(name)
+	if name != "." {
+		prefix += "/"
+	}
+	list, err := c.access.ListAllObjects(c.container, prefix, "/")
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	entries := make([]fs.DirEntry, 0, len(list))
+	for _, f := range list {
+		info := &objectInfo{file: f}
+		if f.Subdir != "" {
+			info.name = strings.TrimSuffix(strings.TrimPrefix(f.Subdir, prefix), "/")
+			info.dir = true
+		} else {
+			info.name = strings.TrimPrefix(f.Name, prefix)
+		}
+		/* Objects named like directories, "dir/", are not files. */
+		if info.name == "" {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	if len(entries) == 0 && name != "." {
+		info, err := c.lookup("readdir", name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.dir {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// objectInfo implements fs.FileInfo for objects and pseudo
+// directories.
+type objectInfo struct {
+	name string
+	dir  bool
+	file File
+}
+
+func (o *objectInfo) Name() string { return o.name }
+func (o *objectInfo) Size() int64  { return o.file.Bytes }
+func (o *objectInfo) IsDir() bool  { return o.dir }
+func (o *objectInfo) Sys() any     { return o.file }
+
+func (o *objectInfo) Mode() fs.FileMode {
+	if o.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (o *objectInfo) ModTime() time.Time {
+	if o.file.LastModified == nil {
+		return time.Time{}
+	}
+	return *o.file.LastModified
+}
+
+// containerFile is an open object. The contents are fetched on the
+// first Read or Seek.
+type containerFile struct {
+	fs     *ContainerFS
+	name   string
+	info   *objectInfo
+	reader *bytes.Reader
+	closed bool
+}
+
+func (f *containerFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *containerFile) load(op string) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.reader != nil {
+		return nil
+	}
+	contents, _, err := f.fs.access.ObjectStoreDownload(
+		f.fs.container + "/" + f.fs.objectName(f.name),
+	)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: f.name, Err: err}
+	}
+	f.reader = bytes.NewReader(contents)
+	return nil
+}
+
+func (f *containerFile) Read(p []byte) (int, error) {
+	if err := f.load("read"); err != nil {
+		return 0, err
+	}
+	return f.reader.Read(p)
+}
+
+func (f *containerFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.load("read"); err != nil {
+		return 0, err
+	}
+	return f.reader.ReadAt(p, off)
+}
+
+func (f *containerFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.load("seek"); err != nil {
+		return 0, err
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *containerFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	f.reader = nil
+	return nil
+}
+
+// containerDir is an open pseudo directory.
+type containerDir struct {
+	fs      *ContainerFS
+	name    string
+	info    *objectInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *containerDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *containerDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *containerDir) Close() error { return nil }
+
+// ReadDir implements fs.ReadDirFile.
+func (d *containerDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
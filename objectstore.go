@@ -31,98 +31,391 @@
 package hpcloud
 
 import (
+	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"mime"
 	"net/http"
+	"net/url"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
+/*
+ ObjectCodec transforms the contents of an object on the way into and
+ out of the object store.
+
+ Encode is called before the object is uploaded and may add to the
+ headers which are sent with it, this is how a codec records whatever
+ it needs in the object's metadata to reverse the transformation.
+ Decode is called with the headers the object store returned and
+ should leave contents untouched if those headers show that the
+ object was not encoded by this codec.
+*/
+type ObjectCodec interface {
+	Encode(contents []byte, header http.Header) ([]byte, error)
+	Decode(contents []byte, header http.Header) ([]byte, error)
+}
+
+/*
+ UploadOptions describes how an object should be stored. The zero
+ value uploads the file under its own name with a Content-Type taken
+ from its extension.
+*/
+type UploadOptions struct {
+	/*
+	  Name is the unescaped name of the object, which may include "/"
+	  to place it in a pseudo directory. It defaults to the base name
+	  of the file being uploaded.
+	*/
+	Name string
+	/*
+	  ContentType overrides the Content-Type. When it is empty the
+	  type is found from the file extension and failing that by
+	  sniffing the contents, or is application/octet-stream when
+	  there are no contents to sniff.
+	*/
+	ContentType        string
+	ContentDisposition string
+	ContentEncoding    string
+	/* Metadata is sent as X-Object-Meta-<key> headers. */
+	Metadata map[string]string
+	/* DeleteAt has the object store expire the object at that time. */
+	DeleteAt time.Time
+	/*
+	  CreateOnly makes the upload fail, with a StatusError of 412, if
+	  the object already exists.
+	*/
+	CreateOnly bool
+	/* Header holds any other headers to send with the upload. */
+	Header http.Header
+	/*
+	  Codecs are applied to the contents in the order they are given
+	  before they are uploaded. The hash is taken of the encoded
+	  contents, so it is what is stored in the object store which is
+	  checked.
+	*/
+	Codecs []ObjectCodec
+}
+
+/*
+ header builds the headers for an upload of contents. The
+ Content-Type is sniffed from the contents before any codecs are
+ applied.
+*/
+func (o *UploadOptions) header(contents []byte) http.Header {
+	h := http.Header{}
+	for key, value := range o.Header {
+		h[key] = append([]string(nil), value...)
+	}
+	ct := o.ContentType
+	if ct == "" {
+		ct = mime.TypeByExtension(path.Ext(o.Name))
+	}
+	if ct == "" && len(contents) == 0 {
+		ct = "application/octet-stream"
+	}
+	if ct == "" {
+		ct = http.DetectContentType(contents)
+	}
+	h.Set("Content-Type", ct)
+	if o.ContentDisposition != "" {
+		h.Set("Content-Disposition", o.ContentDisposition)
+	}
+	if o.ContentEncoding != "" {
+		h.Set("Content-Encoding", o.ContentEncoding)
+	}
+	for key, value := range o.Metadata {
+		h.Set("X-Object-Meta-"+key, value)
+	}
+	if !o.DeleteAt.IsZero() {
+		h.Set("X-Delete-At", strconv.FormatInt(o.DeleteAt.Unix(), 10))
+	}
+	if o.CreateOnly {
+		h.Set("If-None-Match", "*")
+	}
+	return h
+}
+
 /*
  ObjectStoreUpload allows you to upload a file onto the HPCloud, it will
  hash the file and check the returned hash to ensure end-to-end integrity.
 
- It also takes an optional header which will have it's contents added
- to the request.
+ The options may be nil, in which case the defaults described on
+ UploadOptions are used.
 */
-func (a Access) ObjectStoreUpload(filename, container string, header *http.Header) error {
+func (a Access) ObjectStoreUpload(filename, container string, opts *UploadOptions) error {
 	f, err := OpenAndHashFile(filename)
 	if err != nil {
 		return err
 	}
-	client := &http.Client{}
-	path := fmt.Sprintf("%s%s/%s/%s", OBJECT_STORE, a.TenantID, container, filepath.Base(filename))
+	o := UploadOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Name == "" {
+		o.Name = filepath.Base(filename)
+	}
+	return a.ObjectStoreUploadBytes(f.filecontents, container, &o)
+}
+
+/*
+ ObjectStoreUploadBytes uploads contents which are already in memory
+ into the container, otherwise it behaves exactly as ObjectStoreUpload
+ does. The options must include the object's Name.
+*/
+func (a Access) ObjectStoreUploadBytes(contents []byte, container string, opts *UploadOptions) error {
+	if opts == nil || opts.Name == "" {
+		return errors.New("An object name is required.")
+	}
+	h := opts.header(contents)
+	var err error
+	for _, codec := range opts.Codecs {
+		contents, err = codec.Encode(contents, h)
+		if err != nil {
+			return err
+		}
+	}
+	f := NewHashedFile(contents)
+
+	path := fmt.Sprintf("%s/%s/%s", a.objectStoreURL(), escapeObjectName(container), escapeObjectName(opts.Name))
 	req, err := http.NewRequest("PUT", path, f)
 	if err != nil {
 		return err
 	}
-
-	req.Header.Add("Content-Type", mime.TypeByExtension(filepath.Ext(filename)))
+	req.ContentLength = int64(f.Length)
+	for key, value := range h {
+		req.Header[key] = value
+	}
 	req.Header.Add("Etag", f.Hash())
 	req.Header.Add("X-Auth-Token", a.AuthToken())
-	if header != nil {
-		for key, value := range *header {
-			for _, s := range value {
-				req.Header.Add(key, s)
-			}
-		}
-	}
 
-	resp, err := client.Do(req)
+	resp, err := a.Client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return StatusError{http.StatusCreated, resp.StatusCode}
+	}
 	if resp.Header.Get("Etag") != f.Hash() {
 		return errors.New("MD5 hashes do not match. Integrity not guaranteed.")
 	}
-	if resp.StatusCode != http.StatusCreated {
-		return errors.New(fmt.Sprintf("Non-201 status code: %d", resp.StatusCode))
+	return nil
+}
+
+/*
+ ObjectStoreDownload retrieves the object at filename, which includes
+ the container, along with the headers the object store sent with it.
+
+ Like the container and object names taken by the other object store
+ calls, filename is given unescaped and is escaped here.
+
+ The contents are hashed and checked against the returned Etag before
+ the codecs are applied. Codecs are applied in the reverse order to
+ the one given, so the same list which was passed to ObjectStoreUpload
+ can be used here.
+
+ The Etag of a segmented object is not the hash of its contents, so
+ these are not checked.
+*/
+func (a Access) ObjectStoreDownload(filename string, codecs ...ObjectCodec) ([]byte, http.Header, error) {
+	path := fmt.Sprintf("%s/%s", a.objectStoreURL(), escapeObjectName(filename))
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Add("X-Auth-Token", a.AuthToken())
+	/*
+	  Asking for the identity encoding stops the client transparently
+	  decompressing the body, which would make the Etag useless.
+	*/
+	req.Header.Add("Accept-Encoding", "identity")
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, StatusError{http.StatusOK, resp.StatusCode}
+	}
+	f := &HashedFile{MD5: md5.New()}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return nil, nil, err
+	}
+	etag := resp.Header.Get("Etag")
+	if etag != "" && resp.Header.Get("X-Object-Manifest") == "" && etag != f.Hash() {
+		return nil, nil, errors.New("MD5 hashes do not match. Integrity not guaranteed.")
+	}
+	contents := f.filecontents
+	for i := len(codecs) - 1; i >= 0; i-- {
+		contents, err = codecs[i].Decode(contents, resp.Header)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return contents, resp.Header, nil
+}
+
+/*
+ ObjectStoreGet requests the object at filename, which includes the
+ container, and returns the response without reading the body so that
+ large objects can be streamed. The header is sent along with the
+ request, so it can carry Range and conditional headers.
+
+ A 200, 206 or 304 response is returned as it is and the caller must
+ close its body, any other status is returned as a StatusError. Unlike
+ ObjectStoreDownload nothing is hashed or decoded.
+*/
+func (a Access) ObjectStoreGet(filename string, header http.Header) (*http.Response, error) {
+	path := fmt.Sprintf("%s/%s", a.objectStoreURL(), escapeObjectName(filename))
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range header {
+		req.Header[key] = value
+	}
+	req.Header.Set("X-Auth-Token", a.AuthToken())
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+		return resp, nil
+	}
+	resp.Body.Close()
+	return nil, StatusError{http.StatusOK, resp.StatusCode}
+}
+
+/*
+ ObjectStoreHead returns the headers, and so the metadata, of either
+ a container or an object in a container.
+*/
+func (a Access) ObjectStoreHead(filename string) (http.Header, error) {
+	path := fmt.Sprintf("%s/%s", a.objectStoreURL(), escapeObjectName(filename))
+	req, err := http.NewRequest("HEAD", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-Auth-Token", a.AuthToken())
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return nil, StatusError{http.StatusOK, resp.StatusCode}
+	}
+	return resp.Header, nil
+}
+
+/*
+ CreateContainer creates a new container in the object store, it is
+ not an error if the container already exists.
+*/
+func (a Access) CreateContainer(container string) error {
+	path := fmt.Sprintf("%s/%s", a.objectStoreURL(), escapeObjectName(container))
+	req, err := http.NewRequest("PUT", path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("X-Auth-Token", a.AuthToken())
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
+		return StatusError{http.StatusCreated, resp.StatusCode}
 	}
 	return nil
 }
 
+/*
+ ListContainers lists every container in the account.
+*/
+func (a Access) ListContainers() ([]Container, error) {
+	containers := []Container{}
+	marker := ""
+	for {
+		path := fmt.Sprintf("%s?format=json", a.objectStoreURL())
+		if marker != "" {
+			path += "&marker=" + url.QueryEscape(marker)
+		}
+		body, err := a.baseRequest(path, "GET", nil)
+		if err != nil {
+			return nil, err
+		}
+		page := []Container{}
+		if len(body) > 0 {
+			if err = json.Unmarshal(body, &page); err != nil {
+				return nil, err
+			}
+		}
+		if len(page) == 0 {
+			return containers, nil
+		}
+		containers = append(containers, page...)
+		marker = page[len(page)-1].Name
+	}
+}
+
+/*
+ ObjectStoreDelete deletes the object at filename, which includes the
+ container, or the container itself when it is empty.
+*/
 func (a Access) ObjectStoreDelete(filename string) error {
-	client := &http.Client{}
-	path := fmt.Sprintf("%s%s/%s", OBJECT_STORE, a.TenantID, filename)
+	path := fmt.Sprintf("%s/%s", a.objectStoreURL(), escapeObjectName(filename))
 	req, err := http.NewRequest("DELETE", path, nil)
 	if err != nil {
 		return err
 	}
 	req.Header.Add("X-Auth-Token", a.AuthToken())
-	resp, err := client.Do(req)
+	resp, err := a.Client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusNoContent {
-		return errors.New(fmt.Sprintf("Non-204 status code: %d", resp.StatusCode))
+		return StatusError{http.StatusNoContent, resp.StatusCode}
 	}
 	return nil
 }
 
 func (a Access) ListObjects(directory string) (*FileList, error) {
-	path := fmt.Sprintf("%s%s/%s", OBJECT_STORE, a.TenantID, directory)
+	path := fmt.Sprintf("%s/%s", a.objectStoreURL(), escapeObjectName(directory))
 	body, err := a.baseRequest(path, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
 	fl := &FileList{}
 	err = json.Unmarshal(body, fl)
 	if err != nil {
 		return nil, err
 	}
-	// TODO: Put in the date parsing here.
+	fl.parseDates()
 	return fl, nil
 }
 
 /*
  TemporaryURL will generate the temporary URL for the supplied filename.
+
+ The object store signs the path as it is after being unescaped, so
+ the signature is over the name as given while the URL escapes it.
 */
 func (a Access) TemporaryURL(filename, expires string) string {
 	hmac_path := fmt.Sprintf("/v1.0/%s/%s", a.TenantID, filename)
 	hmac_body := fmt.Sprintf("%s\n%s\n%s", "GET", expires, hmac_path)
-	return fmt.Sprintf("%s%s/%s?temp_url_sig=%s&temp_url_expires=%s",
-		OBJECT_STORE, a.TenantID, filename, a.HMAC(a.SecretKey, a.TenantID, hmac_body),
+	return fmt.Sprintf("%s/%s?temp_url_sig=%s&temp_url_expires=%s",
+		a.objectStoreURL(), escapeObjectName(filename), a.HMAC(a.SecretKey, a.TenantID, hmac_body),
 		expires,
 	)
 }
@@ -134,6 +427,41 @@ type File struct {
 	Bytes           int64  `json:"bytes"`
 	Name            string `json:"name"`
 	ContentType     string `json:"content_type"`
+	Subdir          string `json:"subdir"`
+}
+
+/*
+ objectStoreURL is the URL of the account in the object store, using
+ the Access's own ObjectStore endpoint if it has one.
+*/
+func (a Access) objectStoreURL() string {
+	base := OBJECT_STORE
+	if a.ObjectStore != "" {
+		base = strings.TrimSuffix(a.ObjectStore, "/") + "/"
+	}
+	return base + a.TenantID
+}
+
+/*
+ escapeObjectName escapes each segment of an object name so that it
+ can be used in a URL, leaving the "/" between them.
+
+ Every function here which takes a container, an object name or a
+ "container/object" path expects it unescaped and escapes it with
+ this, so callers never escape names themselves.
+*/
+func escapeObjectName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
 }
 
 type FileList []File
+
+type Container struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
@@ -31,8 +31,24 @@
 package hpcloud
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestObjectStoreUpload(t *testing.T) {
@@ -55,10 +71,636 @@ func TestObjectStoreUpload(t *testing.T) {
 		w.Header().Add("Etag", req.Header.Get("Etag"))
 		w.WriteHeader(http.StatusCreated)
 	})
-	h := &http.Header{}
+	h := http.Header{}
 	h.Add("fake", "value")
-	err := test_account.ObjectStoreUpload("testfile.png", "test_container", h)
+	err := test_account.ObjectStoreUpload("testfile.png", "test_container", &UploadOptions{Header: h})
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+/*
+ objectStoreStandIn stores whatever is PUT to it and serves it back,
+ headers included, on a GET to the same path. A GET on a container
+ lists the objects stored in it.
+*/
+func objectStoreStandIn(t *testing.T, check http.HandlerFunc) {
+	type object struct {
+		contents []byte
+		header   http.Header
+	}
+	objects := map[string]object{}
+	var mu sync.Mutex
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.Method {
+		case "PUT":
+			/* Containers are not tracked, only objects. */
+			if strings.Count(strings.Trim(req.URL.Path, "/"), "/") < 3 {
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			if check != nil {
+				check(w, req)
+			}
+			contents, _ := ioutil.ReadAll(req.Body)
+			if req.Header.Get("Etag") != fmt.Sprintf("%x", md5.Sum(contents)) {
+				t.Error("Etag is not the hash of the uploaded contents.")
+			}
+			objects[req.URL.Path] = object{contents, req.Header}
+			w.Header().Add("Etag", req.Header.Get("Etag"))
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			delete(objects, req.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		case "GET", "HEAD":
+			if req.URL.Query().Get("format") == "json" {
+				listObjectsStandIn(w, req, func() map[string][]byte {
+					contents := map[string][]byte{}
+					for name, o := range objects {
+						contents[name] = o.contents
+					}
+					return contents
+				}())
+				return
+			}
+			o, ok := objects[req.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"itemNotFound":{"message":"Not found"}}`))
+				return
+			}
+			for key, value := range o.header {
+				w.Header()[key] = value
+			}
+			if req.Method == "GET" {
+				w.Write(o.contents)
+			}
+		}
+	})
+}
+
+/*
+ listObjectsStandIn answers a container listing from the full object
+ paths in objects, honouring prefix, delimiter, marker and limit. Pages
+ are kept small by default so that callers have to follow markers.
+*/
+func listObjectsStandIn(w http.ResponseWriter, req *http.Request, objects map[string][]byte) {
+	q := req.URL.Query()
+	container := req.URL.Path + "/"
+	prefix, delimiter, marker := q.Get("prefix"), q.Get("delimiter"), q.Get("marker")
+	limit := 3
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil {
+		limit = l
+	}
+	names := []string{}
+	for name := range objects {
+		if strings.HasPrefix(name, container) {
+			names = append(names, strings.TrimPrefix(name, container))
+		}
+	}
+	sort.Strings(names)
+	list := []map[string]interface{}{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if len(list) == limit {
+			break
+		}
+		if !strings.HasPrefix(name, prefix) || name <= marker {
+			continue
+		}
+		if delimiter != "" {
+			rest := strings.TrimPrefix(name, prefix)
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				subdir := prefix + rest[:i+len(delimiter)]
+				if !seen[subdir] && subdir > marker {
+					list = append(list, map[string]interface{}{"subdir": subdir})
+				}
+				seen[subdir] = true
+				continue
+			}
+		}
+		list = append(list, map[string]interface{}{
+			"name":          name,
+			"bytes":         len(objects[container+name]),
+			"hash":          fmt.Sprintf("%x", md5.Sum(objects[container+name])),
+			"last_modified": "2013-03-27T15:22:26.123456",
+		})
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func TestObjectStoreEncryptedRoundTrip(t *testing.T) {
+	keys := StaticKeys{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")},
+	}
+	plain, err := ioutil.ReadFile("testfile.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []byte
+	stored_header := http.Header{}
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "PUT":
+			stored, _ = ioutil.ReadAll(req.Body)
+			if bytes.Equal(stored, plain) {
+				t.Error("Object was uploaded unencrypted.")
+			}
+			if req.Header.Get("X-Object-Meta-Crypto-Key-Id") != "k1" {
+				t.Error("Key ID missing from the object metadata.")
+			}
+			if req.Header.Get("Etag") != fmt.Sprintf("%x", md5.Sum(stored)) {
+				t.Error("Etag is not the hash of the ciphertext.")
+			}
+			for key, value := range req.Header {
+				stored_header[key] = value
+			}
+			w.Header().Add("Etag", req.Header.Get("Etag"))
+			w.WriteHeader(http.StatusCreated)
+		case "GET":
+			for key, value := range stored_header {
+				w.Header()[key] = value
+			}
+			w.Write(stored)
+		}
+	})
+	codec := Encryption{Keys: keys}
+	err = test_account.ObjectStoreUpload("testfile.png", "test_container", &UploadOptions{
+		Codecs: []ObjectCodec{codec},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, _, err := test_account.ObjectStoreDownload("test_container/testfile.png", codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, plain) {
+		t.Error("Decrypted contents do not match the original file.")
+	}
+}
+
+func TestEncryptionRejectsUnauthenticatedObjects(t *testing.T) {
+	codec := Encryption{Keys: StaticKeys{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")},
+	}}
+	if _, err := codec.Decode([]byte("plain"), http.Header{}); err == nil {
+		t.Error("Unencrypted object was accepted.")
+	}
+	codec.AllowUnencrypted = true
+	contents, err := codec.Decode([]byte("plain"), http.Header{})
+	if err != nil || string(contents) != "plain" {
+		t.Errorf("Unencrypted object was not passed through: %q, %v", contents, err)
+	}
+	header := http.Header{"Content-Encoding": {"gzip"}}
+	sealed, err := codec.Encode([]byte("secret"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Set(cryptoContentEncodingHeader, "identity")
+	if _, err := codec.Decode(sealed, header); err == nil {
+		t.Error("Tampered content encoding was accepted.")
+	}
+}
+
+func TestObjectStoreCompressedRoundTrip(t *testing.T) {
+	plain, err := ioutil.ReadFile("testfile.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, encoding := range []string{Gzip, Zstd} {
+		objectStoreStandIn(t, func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Content-Encoding") != encoding {
+				t.Errorf("Content-Encoding %s missing from compressed upload.", encoding)
+			}
+			if req.Header.Get("X-Object-Meta-Original-Size") != fmt.Sprint(len(plain)) {
+				t.Error("Original size missing from compressed upload.")
+			}
+		})
+		codec := Compression{Encoding: encoding}
+		err = test_account.ObjectStoreUpload("testfile.png", "test_container", &UploadOptions{
+			Codecs: []ObjectCodec{codec},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, header, err := test_account.ObjectStoreDownload("test_container/testfile.png", codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(contents, plain) {
+			t.Errorf("%s decompressed contents do not match the original file.", encoding)
+		}
+		if header.Get("Content-Encoding") != "" {
+			t.Error("Content-Encoding left on decompressed contents.")
+		}
+	}
+}
+
+func TestContainerFS(t *testing.T) {
+	objectStoreStandIn(t, nil)
+	for _, name := range []string{"testfile.png", "docs/testfile.png", "docs/img/testfile.png"} {
+		err := test_account.ObjectStoreUpload("testfile.png", "site", &UploadOptions{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := test_account.ContainerFS("site", "")
+	err := fstest.TestFS(fsys, "testfile.png", "docs/testfile.png", "docs/img/testfile.png")
+	if err != nil {
+		t.Error(err)
+	}
+	err = fstest.TestFS(test_account.ContainerFS("site", "docs"), "testfile.png", "img/testfile.png")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContainerFSNames(t *testing.T) {
+	objectStoreStandIn(t, nil)
+	names := []string{"100%.txt", "a?b#c", "report-1", "report-2", "report-3", "report/q1.txt"}
+	for _, name := range names {
+		err := test_account.ObjectStoreUploadBytes([]byte(name), "site", &UploadOptions{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := test_account.ContainerFS("site", "")
+	for _, name := range []string{"100%.txt", "a?b#c", "report/q1.txt"} {
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Error(err)
+		} else if string(contents) != name {
+			t.Errorf("Read %q from %s.", contents, name)
+		}
+	}
+	/* The directory comes after a full page of other matches. */
+	if info, err := fs.Stat(fsys, "report"); err != nil || !info.IsDir() {
+		t.Errorf("report was not found as a directory: %v", err)
+	}
+	if _, err := fs.ReadDir(fsys, "report-1"); err == nil {
+		t.Error("Reading a file as a directory did not fail.")
+	}
+}
+
+func TestObjectStoreUploadOptions(t *testing.T) {
+	deleteAt := time.Unix(2000000000, 0)
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.EscapedPath() != "/object_store//test_container/docs/read%20me" {
+			t.Errorf("Uploaded to the wrong path: %s", req.URL.EscapedPath())
+		}
+		expected := map[string]string{
+			"Content-Type":        "text/plain; charset=utf-8",
+			"Content-Disposition": "attachment",
+			"X-Object-Meta-Owner": "me",
+			"X-Delete-At":         "2000000000",
+			"If-None-Match":       "*",
+		}
+		for key, value := range expected {
+			if req.Header.Get(key) != value {
+				t.Errorf("%s: expected %q, got %q", key, value, req.Header.Get(key))
+			}
+		}
+		w.WriteHeader(http.StatusPreconditionFailed)
+	})
+	err := test_account.ObjectStoreUploadBytes([]byte("Plain text."), "test_container", &UploadOptions{
+		Name:               "docs/read me",
+		ContentDisposition: "attachment",
+		Metadata:           map[string]string{"Owner": "me"},
+		DeleteAt:           deleteAt,
+		CreateOnly:         true,
+	})
+	if se, ok := err.(StatusError); !ok || se.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected a 412 StatusError, got: %v", err)
+	}
+}
+
+// countingTransport counts the requests made through it, to show a
+// configured Client is used.
+type countingTransport struct {
+	n *int
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*t.n++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestObjectStoreEscapesNames(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.EscapedPath() {
+		case "/object_store//my%20container%3F/logs%23":
+			w.Write([]byte(`[{"name": "logs#/1", "bytes": 3, "last_modified": "2013-03-27T15:22:26.123456"}]`))
+		case "/object_store//my%20container%3F/old%20file":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	fl, err := test_account.ListObjects("my container?/logs#")
+	if err != nil || len(*fl) != 1 || (*fl)[0].Name != "logs#/1" {
+		t.Errorf("Unexpected listing: %v %v", fl, err)
+	}
+	if _, err := test_account.ListObjects("missing"); err == nil {
+		t.Error("Expected listing a missing container to fail.")
+	} else if se, ok := err.(StatusError); !ok || se.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 StatusError, got: %v", err)
+	}
+
+	requests := 0
+	a := test_account
+	a.Client.Transport = countingTransport{&requests}
+	if err := a.ObjectStoreDelete("my container?/old file"); err != nil {
+		t.Error(err)
+	}
+	if requests != 1 {
+		t.Errorf("ObjectStoreDelete made %d requests through the Access's Client.", requests)
+	}
+
+	a = Access{TenantID: "tenant", SecretKey: "secret", AccessKey: "access"}
+	tempURL := a.TemporaryURL("my container?/a file#1", "1400000000")
+	if !strings.HasPrefix(tempURL, OBJECT_STORE+"tenant/my%20container%3F/a%20file%231?temp_url_sig=") {
+		t.Errorf("TemporaryURL did not escape the name: %s", tempURL)
+	}
+	sig := a.HMAC("secret", "tenant", "GET\n1400000000\n/v1.0/tenant/my container?/a file#1")
+	if !strings.Contains(tempURL, "temp_url_sig="+sig+"&") {
+		t.Errorf("TemporaryURL did not sign the unescaped path: %s", tempURL)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	objectStoreStandIn(t, nil)
+	upload := func(name, contents string) {
+		err := test_account.ObjectStoreUploadBytes([]byte(contents), "archive", &UploadOptions{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload("a", "first")
+	upload("b", "second")
+	upload("c", "third")
+	key := []byte("secret")
+	m, err := test_account.GenerateManifest("archive", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Objects) != 3 || m.Objects[0].SHA256 != fmt.Sprintf("%x", sha256.Sum256([]byte("first"))) {
+		t.Fatalf("Unexpected manifest: %+v", m.Objects)
+	}
+	if err := m.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	b := &bytes.Buffer{}
+	if err := WriteManifest(b, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(bytes.NewReader(b.Bytes()), []byte("wrong")); err == nil {
+		t.Error("Accepted a manifest signed with another key.")
+	}
+	tampered := bytes.Replace(b.Bytes(), []byte(`"name": "c"`), []byte(`"name": "x"`), 1)
+	if _, err := ReadManifest(bytes.NewReader(tampered), key); err == nil {
+		t.Error("Accepted a manifest which was altered after signing.")
+	}
+	m, err = ReadManifest(b, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload("b", "changed")
+	upload("d", "fourth")
+	test_account.ObjectStoreDelete("archive/c")
+	report, err := test_account.VerifyManifest(m, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || fmt.Sprint(report.Missing, report.Altered, report.Extra) != "[c] [b] [d]" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	objectStoreStandIn(t, nil)
+	src, dst := test_account, test_account
+	src.ObjectStore = ts.URL + "/region_b/"
+	dst.ObjectStore = ts.URL + "/region_a/"
+	upload := func(a Access, name, contents string) {
+		err := a.ObjectStoreUploadBytes([]byte(contents), "backups", &UploadOptions{
+			Name:     name,
+			Metadata: map[string]string{"Owner": "me"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload(src, "same", "unchanged")
+	upload(dst, "same", "unchanged")
+	upload(src, "changed", "new contents")
+	upload(dst, "changed", "old contents")
+	upload(src, "new", "only in the source")
+	upload(dst, "stale", "only in the destination")
+
+	m := Mirror{
+		Source:               src,
+		SourceContainer:      "backups",
+		Destination:          dst,
+		DestinationContainer: "backups",
+		Delete:               true,
+	}
+	result, err := m.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(result.Copied)
+	if fmt.Sprint(result.Copied, result.Skipped, result.Deleted, len(result.Failed)) != "[changed new] [same] [stale] 0" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	contents, header, err := dst.ObjectStoreDownload("backups/changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "new contents" {
+		t.Errorf("Mirrored object has the wrong contents: %q", contents)
+	}
+	if header.Get("X-Object-Meta-Owner") != "me" {
+		t.Error("Metadata was not mirrored.")
+	}
+	result, err = m.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Copied) != 0 || len(result.Skipped) != 3 {
+		t.Errorf("Second run was not incremental: %+v", result)
+	}
+
+	err = src.ObjectStoreUploadBytes([]byte("unchanged"), "backups", &UploadOptions{
+		Name:     "same",
+		Metadata: map[string]string{"Owner": "you"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.CompareMetadata = true
+	result, err = m.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Copied, len(result.Skipped)) != "[same] 2" {
+		t.Errorf("Metadata change was not mirrored: %+v", result)
+	}
+	if header, err := dst.ObjectStoreHead("backups/same"); err != nil || header.Get("X-Object-Meta-Owner") != "you" {
+		t.Errorf("Mirrored metadata was not updated: %v", err)
+	}
+
+	m.DestinationContainer = "backups 2?#"
+	result, err = m.Run()
+	if err != nil || len(result.Copied) != 3 || len(result.Failed) != 0 {
+		t.Fatalf("Mirroring into a container needing escaping: %+v %v", result, err)
+	}
+	if contents, _, err := dst.ObjectStoreDownload("backups 2?#/new"); err != nil || string(contents) != "only in the source" {
+		t.Errorf("Mirrored object has the wrong contents: %q %v", contents, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.RunEvery(ctx, time.Hour, nil)
+}
+
+func TestSetContainerWebsite(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.URL.Path != "/object_store//docs" {
+			t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+		}
+		expected := map[string]string{
+			"X-Container-Meta-Web-Index":               "index.html",
+			"X-Container-Meta-Web-Listings":            "true",
+			"X-Remove-Container-Meta-Web-Error":        "x",
+			"X-Remove-Container-Meta-Web-Listings-Css": "x",
+		}
+		for key, value := range expected {
+			if req.Header.Get(key) != value {
+				t.Errorf("%s: expected %q, got %q", key, value, req.Header.Get(key))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	err := test_account.SetContainerWebsite("docs", WebsiteConfig{Index: "index.html", Listings: true})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateContainerMetadata(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.URL.EscapedPath() != "/object_store//my%20docs%3F%23" {
+			t.Errorf("Unexpected request: %s %s", req.Method, req.URL.EscapedPath())
+		}
+		if req.Header.Get("X-Container-Read") != ".r:*" {
+			t.Errorf("ACL was not sent: %v", req.Header)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	err := test_account.UpdateContainerMetadata("my docs?#", http.Header{"X-Container-Read": {".r:*"}})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetContainerWebsite(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "HEAD" || req.URL.Path != "/object_store//docs" {
+			t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+		}
+		w.Header().Set("X-Container-Meta-Web-Index", "index.html")
+		w.Header().Set("X-Container-Meta-Web-Error", "error.html")
+		w.Header().Set("X-Container-Meta-Web-Listings", "true")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	cfg, err := test_account.GetContainerWebsite("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := WebsiteConfig{Index: "index.html", Error: "error.html", Listings: true}
+	if *cfg != expected {
+		t.Errorf("Unexpected website configuration: %+v", *cfg)
+	}
+}
+
+func TestPublishWebsite(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{"index.html": "<html>", "css/site.css": "body {}"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		cdn      int
+		enabled  string
+		expected string
+	}{
+		{http.StatusNotFound, "", "PUT"},
+		{http.StatusNoContent, "False", "POST"},
+		{http.StatusNoContent, "True", ""},
+		{http.StatusUnauthorized, "", ""},
+	} {
+		uploaded := []string{}
+		cdnRequests := []string{}
+		enabled := test.enabled
+		httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasPrefix(req.URL.Path, "/cdn//") {
+				if req.Method == "HEAD" {
+					if enabled == "" {
+						w.WriteHeader(test.cdn)
+						return
+					}
+					w.Header().Set("X-Cdn-Enabled", enabled)
+					w.Header().Set("X-Cdn-Uri", "http://cdn.example.com")
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				cdnRequests = append(cdnRequests, req.Method)
+				enabled = req.Header.Get("X-Cdn-Enabled")
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			if req.Method == "PUT" && req.URL.Path != "/object_store//site" {
+				uploaded = append(uploaded, strings.TrimPrefix(req.URL.Path, "/object_store//site/"))
+				w.Header().Set("Etag", req.Header.Get("Etag"))
+			}
+			if req.Method == "POST" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		})
+		uri, err := test_account.PublishWebsite(dir, "site", WebsiteConfig{Index: "index.html"})
+		if test.cdn == http.StatusUnauthorized {
+			if se, ok := err.(StatusError); !ok || se.StatusCode != http.StatusUnauthorized {
+				t.Errorf("Expected the 401 to be returned, got %v", err)
+			}
+			if len(cdnRequests) != 0 {
+				t.Errorf("CDN was changed after a failed lookup: %v", cdnRequests)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uri != "http://cdn.example.com" {
+			t.Errorf("Unexpected CDN URI: %q", uri)
+		}
+		sort.Strings(uploaded)
+		if fmt.Sprint(uploaded) != "[css/site.css index.html]" {
+			t.Errorf("Unexpected uploads: %v", uploaded)
+		}
+		if fmt.Sprint(cdnRequests) != fmt.Sprint(strings.Fields(test.expected)) {
+			t.Errorf("CDN %d %q: expected %q, got %v", test.cdn, test.enabled, test.expected, cdnRequests)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+)
+
+// The limits the compute API puts on the files injected into servers.
+const (
+	MaxPersonalityFiles      = 5
+	MaxPersonalityFileSize   = 10240
+	MaxPersonalityPathLength = 255
+)
+
+// PersonalityFile is a file injected into a server when it is created
+// or rebuilt. The contents are base64 encoded when they are sent.
+type PersonalityFile struct {
+	Path     string `json:"path"`
+	Contents []byte `json:"contents"`
+}
+
+func (f PersonalityFile) validate() error {
+	if !path.IsAbs(f.Path) {
+		return errors.New(fmt.Sprintf("The personality path %s is not absolute.", f.Path))
+	}
+	if len(f.Path) > MaxPersonalityPathLength {
+		return errors.New(fmt.Sprintf(
+			"The personality path %s is longer than %d bytes.", f.Path, MaxPersonalityPathLength,
+		))
+	}
+	if len(f.Contents) > MaxPersonalityFileSize {
+		return errors.New(fmt.Sprintf(
+			"%s is %d bytes, personality files are limited to %d.",
+			f.Path, len(f.Contents), MaxPersonalityFileSize,
+		))
+	}
+	return nil
+}
+
+// Personality is the list of files injected into a server.
+type Personality []PersonalityFile
+
+// AddBytes adds a file with the contents at the path on the server.
+func (p *Personality) AddBytes(serverPath string, contents []byte) error {
+	f := PersonalityFile{Path: serverPath, Contents: contents}
+	if err := f.validate(); err != nil {
+		return err
+	}
+	if len(*p) >= MaxPersonalityFiles {
+		return errors.New(fmt.Sprintf("A server can't have more than %d personality files.", MaxPersonalityFiles))
+	}
+	*p = append(*p, f)
+	return nil
+}
+
+// AddFile adds the local file at the path on the server.
+func (p *Personality) AddFile(localPath, serverPath string) error {
+	contents, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	return p.AddBytes(serverPath, contents)
+}
+
+func (p Personality) validate() error {
+	if len(p) > MaxPersonalityFiles {
+		return errors.New(fmt.Sprintf("A server can't have more than %d personality files.", MaxPersonalityFiles))
+	}
+	for _, f := range p {
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
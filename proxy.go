@@ -0,0 +1,268 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxiedRequestHeaders are passed from the client through to the
+// object store, allowing ranged and conditional requests.
+var proxiedRequestHeaders = []string{
+	"Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+}
+
+// hopHeaders are not copied from the object store's response.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ObjectProxy is an http.Handler which serves the objects in a
+// container, authenticating each request to the object store with the
+// token from an Access.
+//
+// The request path, less its leading slash, is the name of the object
+// to serve, so the handler is usually mounted with http.StripPrefix.
+// Only GET and HEAD are allowed.
+type ObjectProxy struct {
+	Container string
+	// Index is the object served for directory-style paths, those
+	// which are empty or end in a "/", for example "index.html".
+	Index string
+	// Listings enables HTML listings of pseudo directories which have
+	// no index object.
+	Listings bool
+	// Refresh is called for a new Access when the current token has
+	// expired or is rejected by the object store. Without it the
+	// proxy returns the object store's 401 to the client.
+	Refresh func() (*Access, error)
+
+	mu     sync.RWMutex
+	access Access
+}
+
+// NewObjectProxy returns an ObjectProxy serving the container using
+// the supplied Access.
+func NewObjectProxy(a Access, container string) *ObjectProxy {
+	return &ObjectProxy{Container: container, access: a}
+}
+
+func (p *ObjectProxy) currentAccess() Access {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.access
+}
+
+// refresh replaces the Access, unless another request has already
+// done so since stale was handed out.
+func (p *ObjectProxy) refresh(stale Access) (Access, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.access.AuthToken() != stale.AuthToken() {
+		return p.access, nil
+	}
+	a, err := p.Refresh()
+	if err != nil {
+		return stale, err
+	}
+	p.access = *a
+	return p.access, nil
+}
+
+func (p *ObjectProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	if strings.Contains("/"+name+"/", "/../") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	dir := name == "" || strings.HasSuffix(name, "/")
+	if dir && p.Index == "" {
+		p.serveListing(w, req, name)
+		return
+	}
+	object := name
+	if dir {
+		object += p.Index
+	}
+	resp, err := p.fetch(req, object)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		if dir {
+			p.serveListing(w, req, name)
+			return
+		}
+		/* Redirect pseudo directories to their directory-style path. */
+		if list, err := p.currentAccess().ListObjectsPage(p.Container, name+"/", "/", "", 1); err == nil && len(list) > 0 {
+			http.Redirect(w, req, path.Base(name)+"/", http.StatusMovedPermanently)
+			return
+		}
+	}
+	for key, value := range resp.Header {
+		w.Header()[key] = value
+	}
+	for _, key := range hopHeaders {
+		w.Header().Del(key)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if req.Method != "HEAD" {
+		io.Copy(w, resp.Body)
+	}
+}
+
+// fetch makes the request for object to the object store, refreshing
+// the token and retrying once if it has expired.
+func (p *ObjectProxy) fetch(req *http.Request, object string) (*http.Response, error) {
+	a := p.currentAccess()
+	if p.Refresh != nil && a.TokenExpired(time.Minute) {
+		var err error
+		if a, err = p.refresh(a); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := p.forward(a, req, object)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || p.Refresh == nil {
+		return resp, err
+	}
+	resp.Body.Close()
+	if a, err = p.refresh(a); err != nil {
+		return nil, err
+	}
+	return p.forward(a, req, object)
+}
+
+func (p *ObjectProxy) forward(a Access, req *http.Request, object string) (*http.Response, error) {
+	url := fmt.Sprintf("%s/%s/%s", a.objectStoreURL(), escapeObjectName(p.Container), escapeObjectName(object))
+	preq, err := http.NewRequest(req.Method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	preq = preq.WithContext(req.Context())
+	for _, key := range proxiedRequestHeaders {
+		if value := req.Header.Get(key); value != "" {
+			preq.Header.Set(key, value)
+		}
+	}
+	preq.Header.Set("X-Auth-Token", a.AuthToken())
+	/* Pass any Content-Encoding through untouched. */
+	preq.Header.Set("Accept-Encoding", "identity")
+	return a.Client.Do(preq)
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Listing of /{{.Prefix}}</title></head>
+<body>
+<h1>Listing of /{{.Prefix}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Last modified</th></tr>
+{{if .Prefix}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type listingEntry struct {
+	Name     string
+	Href     string
+	Size     string
+	Modified string
+}
+
+// serveListing writes an HTML listing of the pseudo directory prefix,
+// or a 404 if listings are disabled or the directory is empty.
+func (p *ObjectProxy) serveListing(w http.ResponseWriter, req *http.Request, prefix string) {
+	if !p.Listings {
+		http.NotFound(w, req)
+		return
+	}
+	list, err := p.currentAccess().ListAllObjects(p.Container, prefix, "/")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if len(list) == 0 && prefix != "" {
+		http.NotFound(w, req)
+		return
+	}
+	entries := make([]listingEntry, 0, len(list))
+	for _, f := range list {
+		e := listingEntry{}
+		if f.Subdir != "" {
+			e.Name = strings.TrimPrefix(f.Subdir, prefix)
+		} else {
+			e.Name = strings.TrimPrefix(f.Name, prefix)
+			e.Size = fmt.Sprint(f.Bytes)
+			if f.LastModified != nil {
+				e.Modified = f.LastModified.Format(time.RFC1123)
+			}
+		}
+		if e.Name == "" {
+			continue
+		}
+		e.Href = (&url.URL{Path: e.Name}).String()
+		entries = append(entries, e)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if req.Method == "HEAD" {
+		return
+	}
+	listingTemplate.Execute(w, struct {
+		Prefix  string
+		Entries []listingEntry
+	}{prefix, entries})
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObjectProxy(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Auth-Token") != "newtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("format") == "json" {
+			if req.URL.Query().Get("marker") != "" {
+				w.Write([]byte(`[]`))
+				return
+			}
+			w.Write([]byte(`[{"subdir":"docs/"},{"name":"logo.png","bytes":4}]`))
+			return
+		}
+		switch req.URL.Path {
+		case "/object_store//site/docs/index.html":
+			if req.Header.Get("Range") != "bytes=0-1" {
+				t.Error("Range header was not passed through.")
+			}
+			w.Header().Set("Content-Range", "bytes 0-1/4")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("<h"))
+		case "/object_store//site/a?b#c.txt":
+			w.Write([]byte("abc"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	refreshed := 0
+	p := NewObjectProxy(test_account, "site")
+	p.Index = "index.html"
+	p.Listings = true
+	p.Refresh = func() (*Access, error) {
+		refreshed++
+		a := test_account
+		a.A.Token.ID = "newtoken"
+		return &a, nil
+	}
+
+	req := httptest.NewRequest("GET", "/docs/", nil)
+	req.Header.Set("Range", "bytes=0-1")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "<h" {
+		t.Errorf("Unexpected index response: %d %q", rec.Code, rec.Body.String())
+	}
+	if refreshed != 1 {
+		t.Errorf("Token refreshed %d times, expected once.", refreshed)
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), `<a href="docs/">docs/</a>`) {
+		t.Errorf("Listing missing pseudo directory: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/a%3Fb%23c.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "abc" {
+		t.Errorf("Unexpected response for an escaped name: %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("PUT", "/logo.png", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("Proxy allowed a write.")
+	}
+}
+
+func TestObjectProxyEscapesContainer(t *testing.T) {
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.EscapedPath() {
+		case "/object_store//my%20site%3F%23":
+			if req.URL.Query().Get("marker") != "" {
+				w.Write([]byte(`[]`))
+				return
+			}
+			w.Write([]byte(`[{"name":"logo.png","bytes":4}]`))
+		case "/object_store//my%20site%3F%23/logo.png":
+			w.Write([]byte("logo"))
+		default:
+			t.Errorf("Unescaped request: %s", req.URL.EscapedPath())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	p := NewObjectProxy(test_account, "my site?#")
+	p.Listings = true
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/logo.png", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "logo" {
+		t.Errorf("Unexpected object response: %d %q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), `logo.png`) {
+		t.Errorf("Listing missing object: %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -31,7 +31,6 @@
 package hpcloud
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -165,9 +164,15 @@ func (a Access) GetDBInstance(id string) (*InstDetails, error) {
 	if err != nil {
 		return nil, err
 	}
-	det := &InstDetails{}
-	err = json.Unmarshal(body, det)
-	return det, err
+	type Output struct {
+		I InstDetails `json:"instance"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.I, nil
 }
 
 /*
@@ -288,7 +293,7 @@ type DBInstance struct {
 	Id      string `json:"id"`
 	Links   []Link `json:"links"`
 	Name    string `json:"name"`
-	Status  string `json:"name"`
+	Status  string `json:"status"`
 	Flavor  struct {
 		Name  string `json:"name"`
 		ID    string `json:"id"`
@@ -393,7 +398,7 @@ type DBCredentials struct {
  DB Security Group Create request struct
 */
 type DBSecRuleReq struct {
-	SecurityGroupID string `json:"security_group_rule"`
+	SecurityGroupID string `json:"security_group_id"`
 	Cidr            string `json:"cidr"`
 	FromPort        int64  `json:"from_port"`
 	ToPort          int64  `json:"to_port"`
@@ -401,7 +406,7 @@ type DBSecRuleReq struct {
 
 type DBSecRule struct {
 	ID              string `json:"id"`
-	SecurityGroupID string `json:"security_group_rule"`
+	SecurityGroupID string `json:"security_group_id"`
 	Cidr            string `json:"cidr"`
 	FromPort        int64  `json:"from_port"`
 	ToPort          int64  `json:"to_port"`
@@ -418,50 +423,40 @@ func (f DBFlavors) GetFlavorRef(fn string) string {
 }
 
 /*
- Creates JSON string for Create DB request
+ Creates JSON string for Create DB request. The port defaults to 3306
+ and the dbtype to MySQL 5.5.
 */
 func (db DatabaseReq) MarshalJSON() ([]byte, error) {
-	b := bytes.NewBufferString(`{"instance":{`)
 	if db.Instance.Name == "" {
 		return nil, errors.New("A name is required")
 	}
-	b.WriteString(fmt.Sprintf(`"name":"%s",`, db.Instance.Name))
 	if db.Instance.FlavorRef == "" {
 		return nil, errors.New("Flavor is required")
 	}
-	b.WriteString(fmt.Sprintf(`"flavorRef":"%s",`,
-		db.Instance.FlavorRef))
 	if db.Instance.Port == 0 {
-		b.WriteString(`"port":"3306",`)
-	} else {
-		b.WriteString(fmt.Sprintf(`"port":"%s",`, db.Instance.Port))
+		db.Instance.Port = 3306
 	}
-	b.WriteString(`"dbtype":{`)
-	b.WriteString(`"name":"mysql",`)
-	b.WriteString(`"version":"5.5"}}}`)
-
-	return b.Bytes(), nil
+	if db.Instance.DBType.Name == "" {
+		db.Instance.DBType.Name = "mysql"
+		db.Instance.DBType.Version = "5.5"
+	}
+	type request DatabaseReq
+	return json.Marshal(request(db))
 }
 
 func (rq DBSecRuleReq) MarshalJSON() ([]byte, error) {
-	b := bytes.NewBufferString(`{"security_group_rule":{`)
 	if rq.SecurityGroupID == "" {
 		return nil, errors.New("Security group ID required")
 	}
-	b.WriteString(fmt.Sprintf(`"security_group_id":"%s",`,
-		rq.SecurityGroupID))
 	if rq.Cidr == "" {
 		return nil, errors.New("Cidr is missing")
 	}
-	b.WriteString(fmt.Sprintf(`"cidr":"%s",`, rq.Cidr))
 	if rq.FromPort == 0 {
 		return nil, errors.New("from_port value is missing")
 	}
-	b.WriteString(fmt.Sprintf(`"from_port":%d,`, rq.FromPort))
 	if rq.ToPort == 0 {
 		return nil, errors.New("to_port value is missing")
 	}
-	b.WriteString(fmt.Sprintf(`"to_port":%d}}`, rq.ToPort))
-
-	return b.Bytes(), nil
+	type rule DBSecRuleReq
+	return json.Marshal(map[string]rule{"security_group_rule": rule(rq)})
 }
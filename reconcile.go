@@ -0,0 +1,280 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FirewallRule is a rule of a security group as it is reconciled,
+// allowing traffic on a port range from either a CIDR or the servers
+// in another group, named by SourceGroup, but not both.
+//
+// An empty Protocol is tcp. ID is only set on the rules which already
+// exist.
+type FirewallRule struct {
+	ID          string
+	Protocol    string
+	FromPort    int
+	ToPort      int
+	CIDR        string
+	SourceGroup string
+}
+
+func (r FirewallRule) protocol() string {
+	if r.Protocol == "" {
+		return "tcp"
+	}
+	return strings.ToLower(r.Protocol)
+}
+
+// key identifies the traffic the rule allows, so that equivalent rules
+// written differently compare equal.
+func (r FirewallRule) key() string {
+	cidr := r.CIDR
+	if _, n, err := net.ParseCIDR(cidr); err == nil {
+		cidr = n.String()
+	}
+	return fmt.Sprintf("%s %d-%d %s %s", r.protocol(), r.FromPort, r.ToPort, cidr, r.SourceGroup)
+}
+
+func (r FirewallRule) String() string {
+	from := r.CIDR
+	if r.SourceGroup != "" {
+		from = "group " + r.SourceGroup
+	}
+	return fmt.Sprintf("%s %d-%d from %s", r.protocol(), r.FromPort, r.ToPort, from)
+}
+
+// RulePlan is the changes which make a security group's rules the
+// desired ones.
+type RulePlan struct {
+	Add    []FirewallRule
+	Remove []FirewallRule
+}
+
+// Empty reports whether the group already has the desired rules.
+func (p RulePlan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Remove) == 0
+}
+
+// String lists the changes, one per line, the additions prefixed with
+// + and the removals with -.
+func (p RulePlan) String() string {
+	b := &bytes.Buffer{}
+	for _, r := range p.Add {
+		fmt.Fprintf(b, "+ %s\n", r)
+	}
+	for _, r := range p.Remove {
+		fmt.Fprintf(b, "- %s\n", r)
+	}
+	return b.String()
+}
+
+// planRules works out which of the desired rules need adding and which
+// of the current rules need removing. Rules which are repeated are
+// treated as one, so duplicates of a current rule are removed.
+func planRules(current, desired []FirewallRule) *RulePlan {
+	plan := &RulePlan{}
+	want := map[string]bool{}
+	for _, r := range desired {
+		want[r.key()] = true
+	}
+	have := map[string]bool{}
+	for _, r := range current {
+		if want[r.key()] && !have[r.key()] {
+			have[r.key()] = true
+		} else {
+			plan.Remove = append(plan.Remove, r)
+		}
+	}
+	for _, r := range desired {
+		if !have[r.key()] {
+			have[r.key()] = true
+			plan.Add = append(plan.Add, r)
+		}
+	}
+	sort.SliceStable(plan.Add, func(i, j int) bool { return plan.Add[i].key() < plan.Add[j].key() })
+	sort.SliceStable(plan.Remove, func(i, j int) bool { return plan.Remove[i].key() < plan.Remove[j].key() })
+	return plan
+}
+
+// apply makes the changes in the plan. The rules are added before any
+// are removed, so traffic which is allowed before and after is never
+// blocked in between.
+func (p RulePlan) apply(add, remove func(FirewallRule) error) error {
+	for _, r := range p.Add {
+		if err := add(r); err != nil {
+			return err
+		}
+	}
+	for _, r := range p.Remove {
+		if err := remove(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReconcileSecurityGroupRules makes the rules of the compute security
+// group the desired ones, only adding and removing the rules which
+// differ. With dryRun nothing is changed. The plan is returned either
+// way.
+func (a Access) ReconcileSecurityGroupRules(groupID int64, desired []FirewallRule, dryRun bool) (*RulePlan, error) {
+	/*
+	  Group rules come back without an ip_range, so a rule with both
+	  would never match what exists and be re-added on every run.
+	*/
+	for _, r := range desired {
+		if (r.CIDR == "") == (r.SourceGroup == "") {
+			return nil, errors.New(fmt.Sprintf("Rules allow traffic from either a CIDR or a group: %s", r))
+		}
+	}
+	group, err := a.GetSecurityGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	current := make([]FirewallRule, len(group.Rules))
+	for i, r := range group.Rules {
+		current[i] = FirewallRule{
+			ID:          strconv.FormatInt(r.ID, 10),
+			Protocol:    r.IPProtocol,
+			FromPort:    r.FromPort,
+			ToPort:      r.ToPort,
+			CIDR:        r.IPRange.CIDR,
+			SourceGroup: r.Group.Name,
+		}
+	}
+	plan := planRules(current, desired)
+	if dryRun || plan.Empty() {
+		return plan, nil
+	}
+	var groups []SecurityGroup
+	add := func(r FirewallRule) error {
+		req := SecurityGroupRuleReq{
+			ParentGroupID: groupID,
+			IPProtocol:    r.protocol(),
+			FromPort:      r.FromPort,
+			ToPort:        r.ToPort,
+			CIDR:          r.CIDR,
+		}
+		if r.SourceGroup != "" {
+			if groups == nil {
+				var err error
+				if groups, err = a.ListSecurityGroups(); err != nil {
+					return err
+				}
+			}
+			for _, g := range groups {
+				if g.Name == r.SourceGroup {
+					req.GroupID = g.Id
+				}
+			}
+			if req.GroupID == 0 {
+				return errors.New(fmt.Sprintf("No security group named %s", r.SourceGroup))
+			}
+		}
+		_, err := a.CreateSecurityGroupRule(req)
+		return err
+	}
+	remove := func(r FirewallRule) error {
+		id, err := strconv.ParseInt(r.ID, 10, 64)
+		if err != nil {
+			return err
+		}
+		return a.DeleteSecurityGroupRule(id)
+	}
+	return plan, plan.apply(add, remove)
+}
+
+// ReconcileDBSecurityGroupRules is ReconcileSecurityGroupRules for
+// database security groups, whose rules only allow tcp from a CIDR.
+func (a Access) ReconcileDBSecurityGroupRules(groupID string, desired []FirewallRule, dryRun bool) (*RulePlan, error) {
+	for _, r := range desired {
+		if r.SourceGroup != "" || r.protocol() != "tcp" || r.CIDR == "" {
+			return nil, errors.New(fmt.Sprintf("Database groups only allow tcp from a CIDR: %s", r))
+		}
+	}
+	/*
+	  The database API's rules have string IDs and no protocol, unlike
+	  the compute Rule, so they are decoded as the rules it creates.
+	*/
+	body, err := a.baseRequest(
+		fmt.Sprintf("%s%s/security-groups/%s", RDB_URL, a.TenantID, groupID),
+		"GET", nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		S struct {
+			Rules []DBSecRule `json:"rules"`
+		} `json:"security_group"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	current := make([]FirewallRule, len(o.S.Rules))
+	for i, r := range o.S.Rules {
+		current[i] = FirewallRule{
+			ID:       r.ID,
+			FromPort: int(r.FromPort),
+			ToPort:   int(r.ToPort),
+			CIDR:     r.Cidr,
+		}
+	}
+	plan := planRules(current, desired)
+	if dryRun || plan.Empty() {
+		return plan, nil
+	}
+	add := func(r FirewallRule) error {
+		_, err := a.CreateDBSecRule(DBSecRuleReq{
+			SecurityGroupID: groupID,
+			Cidr:            r.CIDR,
+			FromPort:        int64(r.FromPort),
+			ToPort:          int64(r.ToPort),
+		})
+		return err
+	}
+	remove := func(r FirewallRule) error {
+		return a.RemoveDBSecRule(r.ID)
+	}
+	return plan, plan.apply(add, remove)
+}
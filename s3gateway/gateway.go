@@ -0,0 +1,851 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package s3gateway serves a practical subset of the S3 API in front of
+// the HP Cloud object store.
+//
+// Buckets are containers and keys are object names. The supported
+// requests are:
+//
+//   - ListBuckets, CreateBucket, HeadBucket and DeleteBucket
+//   - GetObject (including ranged and conditional requests), HeadObject,
+//     PutObject and DeleteObject
+//   - ListObjects and ListObjectsV2
+//   - CreateMultipartUpload, UploadPart, CompleteMultipartUpload and
+//     AbortMultipartUpload
+//
+// Only path-style addressing is understood. Request signatures are not
+// checked, every request is made with the gateway's own Access, so the
+// gateway should only be reachable by trusted clients.
+//
+// Multipart uploads are stored as segments in a container named after
+// the bucket with SegmentSuffix appended, and completed by writing a
+// dynamic large object manifest which refers to them.
+package s3gateway
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AeroNotix/hpcloud"
+)
+
+// SegmentSuffix is appended to a bucket's name to give the container
+// which holds the segments of its multipart uploads.
+const SegmentSuffix = "_segments"
+
+// uploadIDSize is the number of random bytes in an upload ID, which
+// is hex encoded.
+const uploadIDSize = 16
+
+// DefaultMaxBodySize is the largest request body the gateway accepts
+// when Gateway.MaxBodySize is not set. Bodies are held in memory while
+// they are uploaded, so larger objects should use multipart uploads.
+const DefaultMaxBodySize = 64 << 20
+
+const (
+	s3Namespace     = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeLayout    = "2006-01-02T15:04:05.000Z"
+	defaultMaxKeys  = 1000
+	maxPartNumber   = 10000
+	swiftMetaPrefix = "X-Object-Meta-"
+	amzMetaPrefix   = "X-Amz-Meta-"
+)
+
+// Gateway is an http.Handler which translates S3 requests into object
+// store calls.
+type Gateway struct {
+	// Refresh is called for a new Access when the current token is
+	// about to expire.
+	Refresh func() (*hpcloud.Access, error)
+	// MaxBodySize limits the size of request bodies, such as a
+	// PutObject or UploadPart. Zero means DefaultMaxBodySize.
+	MaxBodySize int64
+
+	mu     sync.Mutex
+	access hpcloud.Access
+}
+
+// New returns a Gateway which makes its object store calls with the
+// supplied Access.
+func New(a hpcloud.Access) *Gateway {
+	return &Gateway{access: a}
+}
+
+func (g *Gateway) currentAccess() (hpcloud.Access, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.Refresh != nil && g.access.TokenExpired(time.Minute) {
+		a, err := g.Refresh()
+		if err != nil {
+			return g.access, err
+		}
+		g.access = *a
+	}
+	return g.access, nil
+}
+
+func (g *Gateway) maxBodySize() int64 {
+	if g.MaxBodySize > 0 {
+		return g.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+// s3Error is both the error returned to clients and the XML body which
+// describes it.
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource,omitempty"`
+	status   int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newError(status int, code, message string) *s3Error {
+	return &s3Error{Code: code, Message: message, status: status}
+}
+
+// translateError maps an error from the object store onto the S3
+// error a client would expect. notFound is the code used for a 404.
+func translateError(err error, notFound string) *s3Error {
+	if e, ok := err.(*s3Error); ok {
+		return e
+	}
+	se, ok := err.(hpcloud.StatusError)
+	if !ok {
+		return newError(http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	switch se.StatusCode {
+	case http.StatusNotFound:
+		return newError(http.StatusNotFound, notFound, "The specified resource does not exist.")
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return newError(http.StatusForbidden, "AccessDenied", "Access Denied")
+	case http.StatusConflict:
+		return newError(http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty.")
+	case http.StatusPreconditionFailed:
+		return newError(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the preconditions you specified did not hold.")
+	case http.StatusRequestedRangeNotSatisfiable:
+		return newError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable.")
+	}
+	return newError(http.StatusInternalServerError, "InternalError", se.Error())
+}
+
+func writeError(w http.ResponseWriter, req *http.Request, e *s3Error) {
+	e.Resource = req.URL.Path
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.status)
+	if req.Method != "HEAD" {
+		writeXMLBody(w, e)
+	}
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	writeXMLBody(w, v)
+}
+
+func writeXMLBody(w io.Writer, v interface{}) {
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func quote(etag string) string {
+	return `"` + strings.Trim(etag, `"`) + `"`
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	a, err := g.currentAccess()
+	if err != nil {
+		writeError(w, req, newError(http.StatusServiceUnavailable, "ServiceUnavailable", err.Error()))
+		return
+	}
+	bucket, key := req.URL.Path, ""
+	bucket = strings.TrimPrefix(bucket, "/")
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	q := req.URL.Query()
+	switch {
+	case bucket == "" && req.Method == "GET":
+		err = g.listBuckets(a, w)
+	case bucket == "":
+		err = newError(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	case key == "":
+		err = g.serveBucket(a, w, req, bucket)
+	case req.Method == "GET" || req.Method == "HEAD":
+		err = g.getObject(a, w, req, bucket, key)
+	case req.Method == "PUT" && q.Get("uploadId") != "":
+		err = g.uploadPart(a, w, req, bucket, q.Get("uploadId"), q.Get("partNumber"))
+	case req.Method == "PUT":
+		err = g.putObject(a, w, req, bucket, key)
+	case req.Method == "DELETE" && q.Get("uploadId") != "":
+		err = g.abortUpload(a, w, bucket, q.Get("uploadId"))
+	case req.Method == "DELETE":
+		err = g.deleteObject(a, w, bucket, key)
+	case req.Method == "POST" && hasQuery(q, "uploads"):
+		err = g.createUpload(a, w, req, bucket, key)
+	case req.Method == "POST" && q.Get("uploadId") != "":
+		err = g.completeUpload(a, w, req, bucket, key, q.Get("uploadId"))
+	default:
+		err = newError(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+	}
+	if err != nil {
+		notFound := "NoSuchKey"
+		if key == "" {
+			notFound = "NoSuchBucket"
+		}
+		writeError(w, req, translateError(err, notFound))
+	}
+}
+
+func hasQuery(q url.Values, name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+func (g *Gateway) listBuckets(a hpcloud.Access, w http.ResponseWriter) error {
+	containers, err := a.ListContainers()
+	if err != nil {
+		return err
+	}
+	result := listAllMyBucketsResult{
+		Xmlns: s3Namespace,
+		Owner: owner{ID: a.TenantID, DisplayName: a.A.User.Name},
+	}
+	/* Containers have no creation date in their listing. */
+	created := time.Unix(0, 0).UTC().Format(s3TimeLayout)
+	for _, c := range containers {
+		if strings.HasSuffix(c.Name, SegmentSuffix) {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucketEntry{c.Name, created})
+	}
+	writeXML(w, result)
+	return nil
+}
+
+func (g *Gateway) serveBucket(a hpcloud.Access, w http.ResponseWriter, req *http.Request, bucket string) error {
+	switch req.Method {
+	case "GET":
+		return g.listObjects(a, w, req, bucket)
+	case "HEAD":
+		_, err := a.ObjectStoreHead(bucket)
+		return err
+	case "PUT":
+		if err := a.CreateContainer(bucket); err != nil {
+			return err
+		}
+		w.Header().Set("Location", "/"+bucket)
+		return nil
+	case "DELETE":
+		if err := a.ObjectStoreDelete(bucket); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return newError(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Marker                *string        `xml:"Marker,omitempty"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	KeyCount              *int           `xml:"KeyCount,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Contents              []objectEntry  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listObjects answers both versions of ListObjects. The version 2
+// continuation token is simply the marker to list from.
+func (g *Gateway) listObjects(a hpcloud.Access, w http.ResponseWriter, req *http.Request, bucket string) error {
+	q := req.URL.Query()
+	maxKeys := defaultMaxKeys
+	if mk := q.Get("max-keys"); mk != "" {
+		n, err := strconv.Atoi(mk)
+		if err != nil || n < 0 {
+			return newError(http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer.")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	/* A missing container lists as a 404 with an HTML body. */
+	if _, err := a.ObjectStoreHead(bucket); err != nil {
+		return err
+	}
+	result := listBucketResult{
+		Xmlns:     s3Namespace,
+		Name:      bucket,
+		Prefix:    q.Get("prefix"),
+		Delimiter: q.Get("delimiter"),
+		MaxKeys:   maxKeys,
+	}
+	v2 := q.Get("list-type") == "2"
+	marker := q.Get("marker")
+	if v2 {
+		result.ContinuationToken = q.Get("continuation-token")
+		result.StartAfter = q.Get("start-after")
+		marker = result.StartAfter
+		if result.ContinuationToken != "" {
+			b, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+			if err != nil {
+				return newError(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect.")
+			}
+			marker = string(b)
+		}
+	} else {
+		result.Marker = &marker
+	}
+
+	var page hpcloud.FileList
+	if maxKeys > 0 {
+		var err error
+		page, err = a.ListObjectsPage(bucket, result.Prefix, result.Delimiter, marker, maxKeys+1)
+		if err != nil {
+			return err
+		}
+	}
+	if len(page) > maxKeys {
+		page = page[:maxKeys]
+		result.IsTruncated = true
+		next := page[len(page)-1].Marker()
+		if v2 {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(next))
+		} else {
+			result.NextMarker = next
+		}
+	}
+	for _, f := range page {
+		if f.Subdir != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{f.Subdir})
+			continue
+		}
+		modified := ""
+		if f.LastModified != nil {
+			modified = f.LastModified.Format(s3TimeLayout)
+		}
+		result.Contents = append(result.Contents, objectEntry{
+			Key:          f.Name,
+			LastModified: modified,
+			ETag:         quote(f.Hash),
+			Size:         f.Bytes,
+			StorageClass: "STANDARD",
+		})
+	}
+	if v2 {
+		count := len(page)
+		result.KeyCount = &count
+	}
+	writeXML(w, result)
+	return nil
+}
+
+// objectHeaders copies the headers of an object which S3 clients
+// understand, translating the metadata prefix.
+func objectHeaders(dst, src http.Header) {
+	for _, key := range []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Last-Modified"} {
+		if v := src.Get(key); v != "" {
+			dst.Set(key, v)
+		}
+	}
+	if etag := src.Get("Etag"); etag != "" {
+		dst.Set("ETag", quote(etag))
+	}
+	for key, value := range src {
+		if strings.HasPrefix(key, swiftMetaPrefix) {
+			dst[amzMetaPrefix+strings.TrimPrefix(key, swiftMetaPrefix)] = value
+		}
+	}
+}
+
+// forwardedHeaders are passed on to the object store with a GetObject,
+// so that ranges and conditions are served without buffering the
+// object here.
+var forwardedHeaders = []string{
+	"Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+}
+
+func (g *Gateway) getObject(a hpcloud.Access, w http.ResponseWriter, req *http.Request, bucket, key string) error {
+	path := bucket + "/" + key
+	if req.Method == "HEAD" {
+		h, err := a.ObjectStoreHead(path)
+		if err != nil {
+			return err
+		}
+		objectHeaders(w.Header(), h)
+		w.Header().Set("Content-Length", h.Get("Content-Length"))
+		return nil
+	}
+	header := http.Header{}
+	for _, key := range forwardedHeaders {
+		if v := req.Header.Get(key); v != "" {
+			header.Set(key, v)
+		}
+	}
+	resp, err := a.ObjectStoreGet(path, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	objectHeaders(w.Header(), resp.Header)
+	for _, key := range []string{"Content-Length", "Content-Range", "Accept-Ranges"} {
+		if v := resp.Header.Get(key); v != "" {
+			w.Header().Set(key, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return nil
+}
+
+// readBody reads the request body, undoing the aws-chunked encoding
+// used by streaming signatures and checking any Content-MD5. Bodies
+// larger than the gateway's MaxBodySize are refused.
+func (g *Gateway) readBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	if req.ContentLength > g.maxBodySize() {
+		return nil, entityTooLarge()
+	}
+	r := http.MaxBytesReader(w, req.Body, g.maxBodySize())
+	var body []byte
+	var err error
+	if strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body, err = readChunked(r)
+	} else {
+		body, err = ioutil.ReadAll(r)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, entityTooLarge()
+	}
+	if err != nil {
+		return nil, newError(http.StatusBadRequest, "IncompleteBody", err.Error())
+	}
+	if sum := req.Header.Get("Content-Md5"); sum != "" {
+		expected, err := base64.StdEncoding.DecodeString(sum)
+		actual := md5.Sum(body)
+		if err != nil || !bytes.Equal(expected, actual[:]) {
+			return nil, newError(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what was received.")
+		}
+	}
+	return body, nil
+}
+
+func entityTooLarge() *s3Error {
+	return newError(http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.")
+}
+
+// readChunked decodes an aws-chunked body. Each chunk is a hex length
+// followed by a signature, a CRLF, the data and another CRLF.
+func readChunked(r io.Reader) ([]byte, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body := []byte{}
+	for {
+		i := bytes.Index(raw, []byte("\r\n"))
+		if i < 0 {
+			return nil, errors.New("Malformed chunk header.")
+		}
+		header := string(raw[:i])
+		if j := strings.Index(header, ";"); j >= 0 {
+			header = header[:j]
+		}
+		size, err := strconv.ParseInt(header, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		raw = raw[i+2:]
+		if size == 0 {
+			return body, nil
+		}
+		if size < 0 {
+			return nil, errors.New("Chunk has a negative size.")
+		}
+		if size > int64(len(raw))-2 {
+			return nil, errors.New("Chunk is shorter than its header claims.")
+		}
+		body = append(body, raw[:size]...)
+		raw = raw[size+2:]
+	}
+}
+
+// uploadOptions translates the headers of an S3 upload into the
+// options for the object store.
+func uploadOptions(req *http.Request, name string) *hpcloud.UploadOptions {
+	opts := &hpcloud.UploadOptions{
+		Name:               name,
+		ContentType:        req.Header.Get("Content-Type"),
+		ContentDisposition: req.Header.Get("Content-Disposition"),
+		Metadata:           map[string]string{},
+	}
+	/* aws-chunked describes the transfer, not the object. */
+	if ce := req.Header.Get("Content-Encoding"); ce != "" && ce != "aws-chunked" {
+		opts.ContentEncoding = strings.TrimPrefix(ce, "aws-chunked,")
+	}
+	for key := range req.Header {
+		if strings.HasPrefix(key, amzMetaPrefix) {
+			opts.Metadata[strings.TrimPrefix(key, amzMetaPrefix)] = req.Header.Get(key)
+		}
+	}
+	return opts
+}
+
+func (g *Gateway) putObject(a hpcloud.Access, w http.ResponseWriter, req *http.Request, bucket, key string) error {
+	if req.Header.Get("X-Amz-Copy-Source") != "" {
+		return newError(http.StatusNotImplemented, "NotImplemented", "CopyObject is not implemented.")
+	}
+	body, err := g.readBody(w, req)
+	if err != nil {
+		return err
+	}
+	err = a.ObjectStoreUploadBytes(body, bucket, uploadOptions(req, key))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", quote(fmt.Sprintf("%x", md5.Sum(body))))
+	return nil
+}
+
+// deleteObject deletes the object, along with its segments if it was
+// a multipart upload. As with S3, deleting a missing key succeeds.
+func (g *Gateway) deleteObject(a hpcloud.Access, w http.ResponseWriter, bucket, key string) error {
+	path := bucket + "/" + key
+	h, err := a.ObjectStoreHead(path)
+	if se, ok := err.(hpcloud.StatusError); ok && se.StatusCode == http.StatusNotFound {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.ObjectStoreDelete(path); err != nil {
+		return err
+	}
+	if prefix, ok := uploadSegments(bucket, h.Get("X-Object-Manifest")); ok {
+		if err := deletePrefix(a, bucket+SegmentSuffix, prefix); err != nil {
+			return err
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// uploadSegments returns the prefix of the segments of a completed
+// multipart upload in the bucket, if the manifest is one which
+// completeUpload wrote. Manifests pointing anywhere else were not made
+// by the gateway, so their segments are not ours to delete.
+func uploadSegments(bucket, manifest string) (string, bool) {
+	manifest, err := url.PathUnescape(manifest)
+	if err != nil {
+		return "", false
+	}
+	prefix := strings.TrimPrefix(manifest, bucket+SegmentSuffix+"/")
+	if prefix == manifest || !strings.HasSuffix(prefix, "/") {
+		return "", false
+	}
+	id := strings.TrimSuffix(prefix, "/")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 2*uploadIDSize {
+		return "", false
+	}
+	return prefix, true
+}
+
+func deletePrefix(a hpcloud.Access, container, prefix string) error {
+	list, err := a.ListAllObjects(container, prefix, "")
+	if err != nil {
+		return err
+	}
+	for _, f := range list {
+		if err := a.ObjectStoreDelete(container + "/" + f.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// createUpload starts a multipart upload. The upload's headers are
+// kept in a placeholder object named after the upload ID until it is
+// completed.
+func (g *Gateway) createUpload(a hpcloud.Access, w http.ResponseWriter, req *http.Request, bucket, key string) error {
+	if _, err := a.ObjectStoreHead(bucket); err != nil {
+		return translateError(err, "NoSuchBucket")
+	}
+	segments := bucket + SegmentSuffix
+	if err := a.CreateContainer(segments); err != nil {
+		return err
+	}
+	id := make([]byte, uploadIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(id)
+	opts := uploadOptions(req, uploadID)
+	/* The placeholder is named after the upload, not the key. */
+	if opts.ContentType == "" {
+		opts.ContentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if err := a.ObjectStoreUploadBytes(nil, segments, opts); err != nil {
+		return err
+	}
+	writeXML(w, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+	return nil
+}
+
+// checkUpload ensures the upload exists, returning the headers it was
+// created with.
+func checkUpload(a hpcloud.Access, bucket, uploadID string) (http.Header, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return nil, newError(http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+	}
+	h, err := a.ObjectStoreHead(bucket + SegmentSuffix + "/" + uploadID)
+	if err != nil {
+		return nil, translateError(err, "NoSuchUpload")
+	}
+	return h, nil
+}
+
+func partName(uploadID string, part int) string {
+	return fmt.Sprintf("%s/%08d", uploadID, part)
+}
+
+func (g *Gateway) uploadPart(a hpcloud.Access, w http.ResponseWriter, req *http.Request, bucket, uploadID, partNumber string) error {
+	part, err := strconv.Atoi(partNumber)
+	if err != nil || part < 1 || part > maxPartNumber {
+		return newError(http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000.")
+	}
+	if _, err := checkUpload(a, bucket, uploadID); err != nil {
+		return err
+	}
+	body, err := g.readBody(w, req)
+	if err != nil {
+		return err
+	}
+	err = a.ObjectStoreUploadBytes(body, bucket+SegmentSuffix, &hpcloud.UploadOptions{
+		Name:        partName(uploadID, part),
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", quote(fmt.Sprintf("%x", md5.Sum(body))))
+	return nil
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// completeUpload checks the parts against the segments which were
+// uploaded, removes any segments which were left out and writes the
+// manifest.
+func (g *Gateway) completeUpload(a hpcloud.Access, w http.ResponseWriter, req *http.Request, bucket, key, uploadID string) error {
+	h, err := checkUpload(a, bucket, uploadID)
+	if err != nil {
+		return err
+	}
+	complete := completeMultipartUpload{}
+	body := http.MaxBytesReader(w, req.Body, g.maxBodySize())
+	if err := xml.NewDecoder(body).Decode(&complete); err != nil || len(complete.Parts) == 0 {
+		return newError(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+	}
+	segments := bucket + SegmentSuffix
+	uploaded, err := a.ListAllObjects(segments, uploadID+"/", "")
+	if err != nil {
+		return err
+	}
+	hashes := map[string]string{}
+	for _, f := range uploaded {
+		hashes[f.Name] = f.Hash
+	}
+	etags := md5.New()
+	last := 0
+	for _, p := range complete.Parts {
+		if p.PartNumber <= last {
+			return newError(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+		}
+		last = p.PartNumber
+		name := partName(uploadID, p.PartNumber)
+		hash, ok := hashes[name]
+		if !ok || strings.Trim(p.ETag, `"`) != hash {
+			return newError(http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+		}
+		delete(hashes, name)
+		b, _ := hex.DecodeString(hash)
+		etags.Write(b)
+	}
+	/* The manifest joins every segment under the prefix. */
+	leftover := make([]string, 0, len(hashes))
+	for name := range hashes {
+		leftover = append(leftover, name)
+	}
+	sort.Strings(leftover)
+	for _, name := range leftover {
+		if err := a.ObjectStoreDelete(segments + "/" + name); err != nil {
+			return err
+		}
+	}
+
+	opts := &hpcloud.UploadOptions{
+		Name:               key,
+		ContentType:        h.Get("Content-Type"),
+		ContentEncoding:    h.Get("Content-Encoding"),
+		ContentDisposition: h.Get("Content-Disposition"),
+		Metadata:           map[string]string{},
+		Header:             http.Header{},
+	}
+	for key := range h {
+		if strings.HasPrefix(key, swiftMetaPrefix) {
+			opts.Metadata[strings.TrimPrefix(key, swiftMetaPrefix)] = h.Get(key)
+		}
+	}
+	/* The manifest is a URL path, unlike the names passed to hpcloud. */
+	opts.Header.Set("X-Object-Manifest", url.PathEscape(segments)+"/"+uploadID+"/")
+	if err := a.ObjectStoreUploadBytes(nil, bucket, opts); err != nil {
+		return err
+	}
+	if err := a.ObjectStoreDelete(segments + "/" + uploadID); err != nil {
+		return err
+	}
+	writeXML(w, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     quote(fmt.Sprintf("%x-%d", etags.Sum(nil), len(complete.Parts))),
+	})
+	return nil
+}
+
+func (g *Gateway) abortUpload(a hpcloud.Access, w http.ResponseWriter, bucket, uploadID string) error {
+	if _, err := checkUpload(a, bucket, uploadID); err != nil {
+		return err
+	}
+	segments := bucket + SegmentSuffix
+	if err := deletePrefix(a, segments, uploadID+"/"); err != nil {
+		return err
+	}
+	if err := a.ObjectStoreDelete(segments + "/" + uploadID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,444 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package s3gateway
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AeroNotix/hpcloud"
+)
+
+type swiftObject struct {
+	contents []byte
+	header   http.Header
+}
+
+// swiftStandIn is just enough of the object store for the gateway:
+// containers, objects, listings and dynamic large objects.
+type swiftStandIn struct {
+	mu         sync.Mutex
+	containers map[string]map[string]swiftObject
+}
+
+func (s *swiftStandIn) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Header.Get("X-Auth-Token") != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v1.0/tenant")
+	path = strings.TrimPrefix(path, "/")
+	container, name := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		container, name = path[:i], path[i+1:]
+	}
+	q := req.URL.Query()
+	switch {
+	case container == "":
+		names := []string{}
+		for c := range s.containers {
+			if c > q.Get("marker") {
+				names = append(names, c)
+			}
+		}
+		sort.Strings(names)
+		list := []hpcloud.Container{}
+		for _, c := range names {
+			list = append(list, hpcloud.Container{Name: c, Count: int64(len(s.containers[c]))})
+		}
+		json.NewEncoder(w).Encode(list)
+	case name == "":
+		s.serveContainer(w, req, container)
+	default:
+		objects, ok := s.containers[container]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch req.Method {
+		case "PUT":
+			contents, _ := ioutil.ReadAll(req.Body)
+			objects[name] = swiftObject{contents, req.Header}
+			w.Header().Set("Etag", fmt.Sprintf("%x", md5.Sum(contents)))
+			w.WriteHeader(http.StatusCreated)
+		case "GET", "HEAD":
+			o, ok := objects[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			for key, value := range o.header {
+				w.Header()[key] = value
+			}
+			contents := o.contents
+			w.Header().Set("Etag", fmt.Sprintf("%x", md5.Sum(contents)))
+			if manifest := o.header.Get("X-Object-Manifest"); manifest != "" {
+				contents = s.joinSegments(manifest)
+				w.Header().Set("Etag", `"dlo"`)
+			}
+			w.Header().Set("Last-Modified", "Wed, 27 Mar 2013 15:22:26 GMT")
+			if req.Method == "GET" {
+				http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(contents))
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		case "DELETE":
+			if _, ok := objects[name]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func (s *swiftStandIn) joinSegments(manifest string) []byte {
+	i := strings.Index(manifest, "/")
+	objects := s.containers[manifest[:i]]
+	names := []string{}
+	for name := range objects {
+		if strings.HasPrefix(name, manifest[i+1:]) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	b := &bytes.Buffer{}
+	for _, name := range names {
+		b.Write(objects[name].contents)
+	}
+	return b.Bytes()
+}
+
+func (s *swiftStandIn) serveContainer(w http.ResponseWriter, req *http.Request, container string) {
+	objects, ok := s.containers[container]
+	switch req.Method {
+	case "PUT":
+		if ok {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		s.containers[container] = map[string]swiftObject{}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch req.Method {
+	case "HEAD":
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if len(objects) > 0 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		delete(s.containers, container)
+		w.WriteHeader(http.StatusNoContent)
+	case "GET":
+		q := req.URL.Query()
+		prefix, delimiter, marker := q.Get("prefix"), q.Get("delimiter"), q.Get("marker")
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		names := []string{}
+		for name := range objects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		list := []hpcloud.File{}
+		for _, name := range names {
+			if !strings.HasPrefix(name, prefix) || name <= marker {
+				continue
+			}
+			if limit > 0 && len(list) == limit {
+				break
+			}
+			if delimiter != "" {
+				rest := strings.TrimPrefix(name, prefix)
+				if i := strings.Index(rest, delimiter); i >= 0 {
+					subdir := prefix + rest[:i+len(delimiter)]
+					if len(list) == 0 || list[len(list)-1].Subdir != subdir {
+						list = append(list, hpcloud.File{Subdir: subdir})
+					}
+					continue
+				}
+			}
+			list = append(list, hpcloud.File{
+				Name:            name,
+				Bytes:           int64(len(objects[name].contents)),
+				Hash:            fmt.Sprintf("%x", md5.Sum(objects[name].contents)),
+				StrLastModified: "2013-03-27T15:22:26.123456",
+			})
+		}
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+func setUp(t *testing.T) (*httptest.Server, *swiftStandIn) {
+	swift := &swiftStandIn{containers: map[string]map[string]swiftObject{}}
+	ss := httptest.NewServer(swift)
+	hpcloud.OBJECT_STORE = ss.URL + "/v1.0/"
+	a := hpcloud.Access{TenantID: "tenant", Authenticated: true}
+	a.A.Token.ID = "token"
+	gw := httptest.NewServer(New(a))
+	t.Cleanup(func() {
+		gw.Close()
+		ss.Close()
+	})
+	return gw, swift
+}
+
+func do(t *testing.T, method, url string, body []byte, header map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp, b
+}
+
+func TestObjects(t *testing.T) {
+	gw, _ := setUp(t)
+	if resp, _ := do(t, "PUT", gw.URL+"/bucket", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("CreateBucket: %d", resp.StatusCode)
+	}
+	for _, key := range []string{"a.txt", "dir/b.txt", "dir/c.txt", "with space.txt"} {
+		resp, _ := do(t, "PUT", gw.URL+"/bucket/"+key, []byte("contents of "+key),
+			map[string]string{"Content-Type": "text/plain", "X-Amz-Meta-Owner": "me"})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PutObject %s: %d", key, resp.StatusCode)
+		}
+		if resp.Header.Get("ETag") != fmt.Sprintf(`"%x"`, md5.Sum([]byte("contents of "+key))) {
+			t.Errorf("PutObject %s returned the wrong ETag: %s", key, resp.Header.Get("ETag"))
+		}
+	}
+
+	resp, body := do(t, "GET", gw.URL+"/bucket/dir/b.txt", nil, map[string]string{"Range": "bytes=0-7"})
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "contents" {
+		t.Errorf("Ranged GetObject: %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Amz-Meta-Owner") != "me" {
+		t.Error("Metadata was not returned as x-amz-meta.")
+	}
+	resp, _ = do(t, "HEAD", gw.URL+"/bucket/with%20space.txt", nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("HeadObject: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	resp, body = do(t, "GET", gw.URL+"/bucket/missing", nil, nil)
+	if resp.StatusCode != http.StatusNotFound || !bytes.Contains(body, []byte("NoSuchKey")) {
+		t.Errorf("Missing key: %d %s", resp.StatusCode, body)
+	}
+
+	var result listBucketResult
+	_, body = do(t, "GET", gw.URL+"/bucket?list-type=2&delimiter=/&max-keys=2", nil, nil)
+	if err := xml.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsTruncated || len(result.Contents) != 1 || len(result.CommonPrefixes) != 1 ||
+		result.CommonPrefixes[0].Prefix != "dir/" {
+		t.Fatalf("Unexpected first page: %s", body)
+	}
+	_, body = do(t, "GET", gw.URL+"/bucket?list-type=2&delimiter=/&max-keys=2&continuation-token="+
+		result.NextContinuationToken, nil, nil)
+	result = listBucketResult{}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.IsTruncated || len(result.Contents) != 1 || result.Contents[0].Key != "with space.txt" {
+		t.Errorf("Unexpected second page: %s", body)
+	}
+
+	if resp, _ := do(t, "DELETE", gw.URL+"/bucket", nil, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Deleted a bucket which was not empty: %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "DELETE", gw.URL+"/bucket/a.txt", nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DeleteObject: %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "GET", gw.URL+"/bucket/a.txt", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Object still exists after DeleteObject: %d", resp.StatusCode)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	gw, swift := setUp(t)
+	do(t, "PUT", gw.URL+"/bucket", nil, nil)
+	_, body := do(t, "POST", gw.URL+"/bucket/big.bin?uploads", nil, map[string]string{"Content-Type": "application/x-big"})
+	initiated := initiateMultipartUploadResult{}
+	if err := xml.Unmarshal(body, &initiated); err != nil || initiated.UploadID == "" {
+		t.Fatalf("CreateMultipartUpload: %v %s", err, body)
+	}
+	parts := []string{"first part, ", "second part, ", "unused part"}
+	etags := []string{}
+	for i, part := range parts {
+		resp, body := do(t, "PUT", fmt.Sprintf("%s/bucket/big.bin?partNumber=%d&uploadId=%s",
+			gw.URL, i+1, initiated.UploadID), []byte(part), nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("UploadPart %d: %d %s", i+1, resp.StatusCode, body)
+		}
+		etags = append(etags, resp.Header.Get("ETag"))
+	}
+	complete := fmt.Sprintf(`<CompleteMultipartUpload>
+<Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part>
+<Part><PartNumber>2</PartNumber><ETag>%s</ETag></Part>
+</CompleteMultipartUpload>`, etags[0], etags[1])
+	resp, body := do(t, "POST", gw.URL+"/bucket/big.bin?uploadId="+initiated.UploadID, []byte(complete), nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Contains(body, []byte("-2&#34;</ETag>")) {
+		t.Fatalf("CompleteMultipartUpload: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, "GET", gw.URL+"/bucket/big.bin", nil, nil)
+	if string(body) != "first part, second part, " {
+		t.Errorf("Multipart object has the wrong contents: %q", body)
+	}
+	if resp.Header.Get("Content-Type") != "application/x-big" {
+		t.Errorf("Multipart object lost its Content-Type: %s", resp.Header.Get("Content-Type"))
+	}
+	if n := len(swift.containers["bucket"+SegmentSuffix]); n != 2 {
+		t.Errorf("Expected 2 segments to remain, found %d.", n)
+	}
+
+	do(t, "DELETE", gw.URL+"/bucket/big.bin", nil, nil)
+	if n := len(swift.containers["bucket"+SegmentSuffix]); n != 0 {
+		t.Errorf("Deleting the object left %d segments behind.", n)
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	_, swift := setUp(t)
+	swift.containers["bucket"] = map[string]swiftObject{}
+	a := hpcloud.Access{TenantID: "tenant", Authenticated: true}
+	a.A.Token.ID = "token"
+	g := New(a)
+	g.MaxBodySize = 64
+	gw := httptest.NewServer(g)
+	defer gw.Close()
+
+	resp, body := do(t, "PUT", gw.URL+"/bucket/big", bytes.Repeat([]byte("x"), 65), nil)
+	if resp.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("EntityTooLarge")) {
+		t.Errorf("Oversized PutObject: %d %s", resp.StatusCode, body)
+	}
+	streaming := map[string]string{"X-Amz-Content-Sha256": "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"}
+	for _, chunked := range []string{
+		"-1;chunk-signature=0\r\nx\r\n0\r\n\r\n",
+		"7fffffffffffffff;chunk-signature=0\r\nx\r\n0\r\n\r\n",
+	} {
+		resp, body = do(t, "PUT", gw.URL+"/bucket/chunked", []byte(chunked), streaming)
+		if resp.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("IncompleteBody")) {
+			t.Errorf("Malformed chunk %q: %d %s", chunked, resp.StatusCode, body)
+		}
+	}
+	resp, _ = do(t, "PUT", gw.URL+"/bucket/chunked", []byte("3;chunk-signature=0\r\nabc\r\n0\r\n\r\n"), streaming)
+	if resp.StatusCode != http.StatusOK || string(swift.containers["bucket"]["chunked"].contents) != "abc" {
+		t.Errorf("Chunked PutObject: %d", resp.StatusCode)
+	}
+}
+
+func TestMultipartUploadContentType(t *testing.T) {
+	gw, _ := setUp(t)
+	do(t, "PUT", gw.URL+"/bucket", nil, nil)
+	for key, expected := range map[string]string{
+		"100%25/photo.png": "image/png",
+		"a%3Fb%23c":        "application/octet-stream",
+	} {
+		_, body := do(t, "POST", gw.URL+"/bucket/"+key+"?uploads", nil, nil)
+		initiated := initiateMultipartUploadResult{}
+		if err := xml.Unmarshal(body, &initiated); err != nil {
+			t.Fatalf("CreateMultipartUpload %s: %v %s", key, err, body)
+		}
+		resp, _ := do(t, "PUT", gw.URL+"/bucket/"+key+"?partNumber=1&uploadId="+initiated.UploadID,
+			[]byte("part"), nil)
+		complete := fmt.Sprintf(`<CompleteMultipartUpload>
+<Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part>
+</CompleteMultipartUpload>`, resp.Header.Get("ETag"))
+		do(t, "POST", gw.URL+"/bucket/"+key+"?uploadId="+initiated.UploadID, []byte(complete), nil)
+		resp, body = do(t, "GET", gw.URL+"/bucket/"+key, nil, nil)
+		if resp.StatusCode != http.StatusOK || string(body) != "part" {
+			t.Errorf("GetObject %s: %d %q", key, resp.StatusCode, body)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != expected {
+			t.Errorf("%s has the Content-Type %s, expected %s.", key, ct, expected)
+		}
+		do(t, "DELETE", gw.URL+"/bucket/"+key, nil, nil)
+	}
+}
+
+func TestDeleteForeignManifest(t *testing.T) {
+	gw, swift := setUp(t)
+	id := strings.Repeat("ab", uploadIDSize)
+	swift.containers["other"] = map[string]swiftObject{"data": {[]byte("other data"), http.Header{}}}
+	swift.containers["bucket"+SegmentSuffix] = map[string]swiftObject{
+		"unrelated":            {[]byte("unrelated"), http.Header{}},
+		id + "/00000001":       {[]byte("segment"), http.Header{}},
+		id + "/00000001/extra": {[]byte("nested"), http.Header{}},
+	}
+	swift.containers["bucket"] = map[string]swiftObject{}
+	for name, manifest := range map[string]string{
+		"other":     "other/",
+		"empty":     "bucket" + SegmentSuffix + "/",
+		"not-an-id": "bucket" + SegmentSuffix + "/unrelated/",
+		"short-id":  "bucket" + SegmentSuffix + "/abab/",
+		"nested":    "bucket" + SegmentSuffix + "/" + id + "/00000001/",
+	} {
+		swift.containers["bucket"][name] = swiftObject{nil, http.Header{"X-Object-Manifest": {manifest}}}
+		resp, body := do(t, "DELETE", gw.URL+"/bucket/"+name, nil, nil)
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("DeleteObject %s: %d %s", name, resp.StatusCode, body)
+		}
+		if _, ok := swift.containers["bucket"][name]; ok {
+			t.Errorf("DeleteObject %s left the manifest behind.", name)
+		}
+		if n := len(swift.containers["other"]) + len(swift.containers["bucket"+SegmentSuffix]); n != 4 {
+			t.Errorf("Deleting %s, a manifest the gateway did not write, deleted its segments.", name)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SecurityGroupRuleReq describes a rule to add to a compute security
+// group. The rule allows traffic from either a CIDR or the servers in
+// another group, GroupID, but not both.
+//
+// For the icmp protocol the ports are the ICMP type and code, where -1
+// matches any.
+type SecurityGroupRuleReq struct {
+	ParentGroupID int64  `json:"parent_group_id"`
+	IPProtocol    string `json:"ip_protocol"`
+	FromPort      int    `json:"from_port"`
+	ToPort        int    `json:"to_port"`
+	CIDR          string `json:"cidr,omitempty"`
+	GroupID       int64  `json:"group_id,omitempty"`
+}
+
+func (r SecurityGroupRuleReq) validate() error {
+	if r.ParentGroupID == 0 {
+		return errors.New("A parent group ID is required.")
+	}
+	min, protocol := 1, strings.ToLower(r.IPProtocol)
+	switch protocol {
+	case "tcp", "udp":
+	case "icmp":
+		min = -1
+	default:
+		return errors.New(fmt.Sprintf("Unknown protocol: %s", r.IPProtocol))
+	}
+	if r.FromPort < min || r.ToPort < min || r.FromPort > 65535 || r.ToPort > 65535 {
+		return errors.New(fmt.Sprintf("Invalid port range: %d-%d", r.FromPort, r.ToPort))
+	}
+	if r.FromPort > r.ToPort && protocol != "icmp" {
+		return errors.New(fmt.Sprintf("Invalid port range: %d-%d", r.FromPort, r.ToPort))
+	}
+	if (r.CIDR == "") == (r.GroupID == 0) {
+		return errors.New("A rule needs either a CIDR or a source group.")
+	}
+	if r.CIDR != "" {
+		if _, _, err := net.ParseCIDR(r.CIDR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSecurityGroups lists the compute security groups.
+func (a Access) ListSecurityGroups() ([]SecurityGroup, error) {
+	body, err := a.baseComputeRequest("os-security-groups", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		S []SecurityGroup `json:"security_groups"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	return o.S, err
+}
+
+// GetSecurityGroup returns the compute security group with its rules.
+func (a Access) GetSecurityGroup(id int64) (*SecurityGroup, error) {
+	body, err := a.baseComputeRequest(
+		fmt.Sprintf("os-security-groups/%d", id), "GET", nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		S SecurityGroup `json:"security_group"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.S, nil
+}
+
+// CreateSecurityGroup creates a compute security group, which has no
+// rules and so allows no traffic until some are added.
+func (a Access) CreateSecurityGroup(name, description string) (*SecurityGroup, error) {
+	if name == "" {
+		return nil, errors.New("A security group name is required.")
+	}
+	type group struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	b, err := json.Marshal(map[string]group{"security_group": {name, description}})
+	if err != nil {
+		return nil, err
+	}
+	body, err := a.baseComputeRequest("os-security-groups", "POST", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		S SecurityGroup `json:"security_group"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.S, nil
+}
+
+// DeleteSecurityGroup deletes a compute security group.
+func (a Access) DeleteSecurityGroup(id int64) error {
+	_, err := a.baseComputeRequest(
+		fmt.Sprintf("os-security-groups/%d", id), "DELETE", nil,
+	)
+	return err
+}
+
+// CreateSecurityGroupRule adds a rule to a compute security group.
+func (a Access) CreateSecurityGroupRule(r SecurityGroupRuleReq) (*Rule, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	r.IPProtocol = strings.ToLower(r.IPProtocol)
+	b, err := json.Marshal(map[string]SecurityGroupRuleReq{"security_group_rule": r})
+	if err != nil {
+		return nil, err
+	}
+	body, err := a.baseComputeRequest("os-security-group-rules", "POST", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		R Rule `json:"security_group_rule"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.R, nil
+}
+
+// DeleteSecurityGroupRule removes a rule from its compute security
+// group.
+func (a Access) DeleteSecurityGroupRule(id int64) error {
+	_, err := a.baseComputeRequest(
+		fmt.Sprintf("os-security-group-rules/%d", id), "DELETE", nil,
+	)
+	return err
+}
+
+// AddServerSecurityGroup adds the security group with the name to a
+// running server.
+func (a Access) AddServerSecurityGroup(server_id, name string) error {
+	return a.serverSecurityGroupAction(server_id, "addSecurityGroup", name)
+}
+
+// RemoveServerSecurityGroup removes the security group with the name
+// from a running server.
+func (a Access) RemoveServerSecurityGroup(server_id, name string) error {
+	return a.serverSecurityGroupAction(server_id, "removeSecurityGroup", name)
+}
+
+func (a Access) serverSecurityGroupAction(server_id, action, name string) error {
+	if name == "" {
+		return errors.New("A security group name is required.")
+	}
+	type group struct {
+		Name string `json:"name"`
+	}
+	return a.serverAction(server_id, map[string]group{action: {name}}, nil)
+}
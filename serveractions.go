@@ -0,0 +1,214 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// RebootType is the kind of reboot RebootServerWithType asks for.
+type RebootType string
+
+const (
+	// SoftReboot asks the operating system to restart.
+	SoftReboot = RebootType("SOFT")
+	// HardReboot power cycles the server.
+	HardReboot = RebootType("HARD")
+)
+
+// Rebuild describes the request to rebuild a server from an image.
+// Only the ImageRef is required, the zero values of the rest are left
+// out, leaving the server's current values.
+type Rebuild struct {
+	ImageRef   ServerImage       `json:"imageRef"`
+	Name       string            `json:"name,omitempty"`
+	AdminPass  string            `json:"adminPass,omitempty"`
+	AccessIPv4 string            `json:"accessIPv4,omitempty"`
+	AccessIPv6 string            `json:"accessIPv6,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	// Personality replaces the files injected into the server.
+	Personality Personality `json:"personality,omitempty"`
+}
+
+func (r Rebuild) validate() error {
+	if r.ImageRef == "" {
+		return errors.New("An image reference is required.")
+	}
+	if err := r.Personality.validate(); err != nil {
+		return err
+	}
+	return validateAccessIPs(r.AccessIPv4, r.AccessIPv6)
+}
+
+// ServerUpdate describes the changes to make to a server with
+// UpdateServer, fields left at their zero values are not changed.
+type ServerUpdate struct {
+	Name       string `json:"name,omitempty"`
+	AccessIPv4 string `json:"accessIPv4,omitempty"`
+	AccessIPv6 string `json:"accessIPv6,omitempty"`
+}
+
+func (u ServerUpdate) validate() error {
+	if u == (ServerUpdate{}) {
+		return errors.New("A server update must change something.")
+	}
+	return validateAccessIPs(u.AccessIPv4, u.AccessIPv6)
+}
+
+func validateAccessIPs(v4, v6 string) error {
+	if ip := net.ParseIP(v4); v4 != "" && (ip == nil || ip.To4() == nil) {
+		return errors.New(fmt.Sprintf("%s is not an IPv4 address.", v4))
+	}
+	if ip := net.ParseIP(v6); v6 != "" && (ip == nil || ip.To4() != nil) {
+		return errors.New(fmt.Sprintf("%s is not an IPv6 address.", v6))
+	}
+	return nil
+}
+
+// serverAction posts the action to the server, decoding the response
+// into out when it is not nil.
+func (a Access) serverAction(server_id string, action interface{}, out interface{}) error {
+	b, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	body, err := a.baseComputeRequest(
+		fmt.Sprintf("servers/%s/action", server_id),
+		"POST", bytes.NewReader(b),
+	)
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// RebootServerWithType reboots the server with the `server_id` with
+// either a SoftReboot or a HardReboot.
+func (a Access) RebootServerWithType(server_id string, t RebootType) error {
+	if t != SoftReboot && t != HardReboot {
+		return errors.New(fmt.Sprintf("Unknown reboot type: %s", t))
+	}
+	type reboot struct {
+		Type RebootType `json:"type"`
+	}
+	return a.serverAction(server_id, map[string]reboot{"reboot": {t}}, nil)
+}
+
+// ResizeServer starts resizing the server to the flavor. Once the
+// server reaches the VERIFY_RESIZE status the resize has to be
+// confirmed with ConfirmResize or undone with RevertResize.
+func (a Access) ResizeServer(server_id string, flavor Flavor) error {
+	if flavor == "" {
+		return errors.New("A flavor reference is required.")
+	}
+	type resize struct {
+		FlavorRef Flavor `json:"flavorRef"`
+	}
+	return a.serverAction(server_id, map[string]resize{"resize": {flavor}}, nil)
+}
+
+// ConfirmResize confirms the resize of the server, which removes the
+// original server.
+func (a Access) ConfirmResize(server_id string) error {
+	return a.serverAction(server_id, map[string]interface{}{"confirmResize": nil}, nil)
+}
+
+// RevertResize undoes the resize of the server, going back to the
+// original server.
+func (a Access) RevertResize(server_id string) error {
+	return a.serverAction(server_id, map[string]interface{}{"revertResize": nil}, nil)
+}
+
+// RebuildServer rebuilds the server from an image, removing all of its
+// data. The returned details include the new AdminPass.
+func (a Access) RebuildServer(server_id string, r Rebuild) (*ServerDetail, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	type Output struct {
+		S ServerDetail `json:"server"`
+	}
+	o := &Output{}
+	err := a.serverAction(server_id, map[string]Rebuild{"rebuild": r}, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.S, nil
+}
+
+// ChangePassword changes the administrator password of the server.
+func (a Access) ChangePassword(server_id, password string) error {
+	if password == "" {
+		return errors.New("A password is required.")
+	}
+	type changePassword struct {
+		AdminPass string `json:"adminPass"`
+	}
+	return a.serverAction(server_id, map[string]changePassword{
+		"changePassword": {password},
+	}, nil)
+}
+
+// UpdateServer changes the name or access addresses of the server,
+// returning its updated details.
+func (a Access) UpdateServer(server_id string, u ServerUpdate) (*ServerDetail, error) {
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(map[string]ServerUpdate{"server": u})
+	if err != nil {
+		return nil, err
+	}
+	body, err := a.baseComputeRequest(
+		fmt.Sprintf("servers/%s", server_id), "PUT", bytes.NewReader(b),
+	)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		S ServerDetail `json:"server"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.S, nil
+}
+
+// RenameServer changes the name of the server.
+func (a Access) RenameServer(server_id, name string) (*ServerDetail, error) {
+	return a.UpdateServer(server_id, ServerUpdate{Name: name})
+}
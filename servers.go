@@ -0,0 +1,216 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// serverTimeLayouts are the layouts the compute API has been seen to
+// use for the created and updated times of servers.
+var serverTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05.999999",
+}
+
+// ServerDetail describes a server as it is returned from the server
+// details resources, rather than the request which created it.
+//
+// The compute API reports the task of a building server alongside the
+// status, "BUILD(scheduling)", these are split into Status and
+// TaskState.
+type ServerDetail struct {
+	ID             int64             `json:"id"`
+	UUID           string            `json:"uuid"`
+	Name           string            `json:"name"`
+	Status         string            `json:"status"`
+	TaskState      string            `json:"OS-EXT-STS:task_state"`
+	VMState        string            `json:"OS-EXT-STS:vm_state"`
+	PowerState     int               `json:"OS-EXT-STS:power_state"`
+	Progress       int               `json:"progress"`
+	HostID         string            `json:"hostId"`
+	UserID         string            `json:"user_id"`
+	TenantID       string            `json:"tenant_id"`
+	Addresses      Addresses         `json:"addresses"`
+	Flavor         IDLink            `json:"flavor"`
+	Image          IDLink            `json:"image"`
+	Metadata       map[string]string `json:"metadata"`
+	KeyName        string            `json:"key_name"`
+	SecurityGroups []SecurityGroup   `json:"security_groups"`
+	AccessIPv4     string            `json:"accessIPv4"`
+	AccessIPv6     string            `json:"accessIPv6"`
+	Links          []Link            `json:"links"`
+	Created        time.Time         `json:"-"`
+	Updated        time.Time         `json:"-"`
+	// AdminPass is only returned when the password has been set, such
+	// as by RebuildServer.
+	AdminPass string `json:"adminPass"`
+}
+
+// UnmarshalJSON parses the times of the server, which are not always
+// in the RFC 3339 format time.Time expects, and splits the task out
+// of the status.
+func (s *ServerDetail) UnmarshalJSON(b []byte) error {
+	type detail ServerDetail
+	aux := struct {
+		*detail
+		Created string `json:"created"`
+		Updated string `json:"updated"`
+	}{detail: (*detail)(s)}
+	err := json.Unmarshal(b, &aux)
+	if err != nil {
+		return err
+	}
+	if s.Created, err = parseServerTime(aux.Created); err != nil {
+		return err
+	}
+	if s.Updated, err = parseServerTime(aux.Updated); err != nil {
+		return err
+	}
+	if i := strings.Index(s.Status, "("); i > 0 && strings.HasSuffix(s.Status, ")") {
+		if s.TaskState == "" {
+			s.TaskState = s.Status[i+1 : len(s.Status)-1]
+		}
+		s.Status = s.Status[:i]
+	}
+	return nil
+}
+
+func parseServerTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range serverTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("Unrecognised server time: %s", value))
+}
+
+// ServerFilter narrows down the servers returned from
+// ListServersDetail. Zero fields are not filtered on.
+type ServerFilter struct {
+	// Name is a regular expression the server names must match.
+	Name   string
+	Status string
+	Image  string
+	Flavor string
+	// ChangesSince only lists the servers which have changed since
+	// the time given, including the ones which have been deleted.
+	ChangesSince time.Time
+}
+
+func (f ServerFilter) query() string {
+	q := url.Values{}
+	if f.Name != "" {
+		q.Set("name", f.Name)
+	}
+	if f.Status != "" {
+		q.Set("status", strings.ToUpper(f.Status))
+	}
+	if f.Image != "" {
+		q.Set("image", f.Image)
+	}
+	if f.Flavor != "" {
+		q.Set("flavor", f.Flavor)
+	}
+	if !f.ChangesSince.IsZero() {
+		q.Set("changes-since", f.ChangesSince.UTC().Format(time.RFC3339))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// GetServer returns the details of the server with the `server_id`.
+func (a Access) GetServer(server_id string) (*ServerDetail, error) {
+	body, err := a.baseComputeRequest(
+		fmt.Sprintf("servers/%s", server_id), "GET", nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	type Output struct {
+		S ServerDetail `json:"server"`
+	}
+	o := &Output{}
+	err = json.Unmarshal(body, o)
+	if err != nil {
+		return nil, err
+	}
+	return &o.S, nil
+}
+
+// ListServersDetail lists the details of the servers matching the
+// filter. The filter is passed on to the compute API, the name is
+// matched again here since not every deployment treats it as a
+// regular expression.
+func (a Access) ListServersDetail(f ServerFilter) ([]ServerDetail, error) {
+	var name *regexp.Regexp
+	if f.Name != "" {
+		var err error
+		name, err = regexp.Compile(f.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	body, err := a.baseComputeRequest("servers/detail"+f.query(), "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	type Servers struct {
+		S []ServerDetail `json:"servers"`
+	}
+	svrs := &Servers{}
+	err = json.Unmarshal(body, svrs)
+	if err != nil {
+		return nil, err
+	}
+	if name == nil {
+		return svrs.S, nil
+	}
+	matching := []ServerDetail{}
+	for _, s := range svrs.S {
+		if name.MatchString(s.Name) {
+			matching = append(matching, s)
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// MaxUserDataSize is the most user data, once base64 encoded, the
+// compute API accepts.
+const MaxUserDataSize = 65535
+
+// CloudConfig is the cloud-config cloud-init applies on a server's
+// first boot.
+type CloudConfig struct {
+	Users             []CloudUser `json:"users,omitempty"`
+	SSHAuthorizedKeys []string    `json:"ssh_authorized_keys,omitempty"`
+	PackageUpdate     bool        `json:"package_update,omitempty"`
+	PackageUpgrade    bool        `json:"package_upgrade,omitempty"`
+	Packages          []string    `json:"packages,omitempty"`
+	WriteFiles        []CloudFile `json:"write_files,omitempty"`
+	// RunCmd are run, by the shell, once the rest of the config has
+	// been applied.
+	RunCmd []string `json:"runcmd,omitempty"`
+}
+
+// CloudUser is a user cloud-init creates. Users replace the image's
+// default user unless "default" is one of them.
+type CloudUser struct {
+	Name              string   `json:"name"`
+	Groups            string   `json:"groups,omitempty"`
+	Shell             string   `json:"shell,omitempty"`
+	Sudo              string   `json:"sudo,omitempty"`
+	SSHAuthorizedKeys []string `json:"ssh_authorized_keys,omitempty"`
+}
+
+// CloudFile is a file cloud-init writes. Permissions are in octal,
+// such as "0644".
+type CloudFile struct {
+	Path        string `json:"path"`
+	Content     string `json:"content"`
+	Owner       string `json:"owner,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// UserData builds the user data for Server.UserData, combining a
+// cloud-config and shell scripts, which are run in order after it.
+type UserData struct {
+	Config  *CloudConfig
+	Scripts []string
+	// Gzip compresses the user data, which cloud-init detects, to fit
+	// more in MaxUserDataSize.
+	Gzip bool
+}
+
+// cloudConfig renders the config. JSON is a subset of YAML, so the
+// indented JSON is a valid cloud-config without a YAML encoder.
+func (c CloudConfig) cloudConfig() ([]byte, error) {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), b...), nil
+}
+
+// Build renders the user data as a MIME multipart document, ready to
+// be set as Server.UserData, which base64 encodes it.
+func (u UserData) Build() (string, error) {
+	if u.Config == nil && len(u.Scripts) == 0 {
+		return "", errors.New("User data needs a cloud-config or a script.")
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part := func(contentType, filename string, contents []byte) error {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", contentType+`; charset="utf-8"`)
+		h.Set("MIME-Version", "1.0")
+		h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		p, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		_, err = p.Write(contents)
+		return err
+	}
+	if u.Config != nil {
+		config, err := u.Config.cloudConfig()
+		if err != nil {
+			return "", err
+		}
+		if err = part("text/cloud-config", "cloud-config.txt", config); err != nil {
+			return "", err
+		}
+	}
+	for i, script := range u.Scripts {
+		if !strings.HasPrefix(script, "#!") {
+			return "", errors.New(fmt.Sprintf("Script %d does not start with #!", i+1))
+		}
+		filename := fmt.Sprintf("script-%d.sh", i+1)
+		if err := part("text/x-shellscript", filename, []byte(script)); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	var doc bytes.Buffer
+	fmt.Fprintf(&doc, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n", w.Boundary())
+	doc.WriteString("MIME-Version: 1.0\r\n\r\n")
+	body.WriteTo(&doc)
+	userData := doc.String()
+	if u.Gzip {
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		if _, err := doc.WriteTo(zw); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		userData = gz.String()
+	}
+	if err := checkUserDataSize(userData); err != nil {
+		return "", err
+	}
+	return userData, nil
+}
+
+func checkUserDataSize(userData string) error {
+	if size := base64.StdEncoding.EncodedLen(len(userData)); size > MaxUserDataSize {
+		return errors.New(fmt.Sprintf(
+			"User data is %d bytes once encoded, the limit is %d.", size, MaxUserDataSize,
+		))
+	}
+	return nil
+}
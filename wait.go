@@ -0,0 +1,207 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WaitOptions control how often WaitFor polls and who is told about
+// it. A nil *WaitOptions uses the defaults.
+type WaitOptions struct {
+	// Interval is the time waited before the second poll, it doubles
+	// after every poll up to MaxInterval. It defaults to two seconds.
+	Interval time.Duration
+	// MaxInterval defaults to thirty seconds.
+	MaxInterval time.Duration
+	// Progress, when set, is called with the status and progress of
+	// the resource after every poll. Resources which don't report
+	// their progress report 0.
+	Progress func(status string, progress int)
+}
+
+// Poll fetches the current status and progress of a resource. The
+// request it makes should be cancelled along with the context.
+type Poll func(ctx context.Context) (status string, progress int, err error)
+
+// WaitFor polls until the status is target, which is compared without
+// regard to case, and returns it. It fails as soon as the status is
+// one of failed, or an error status such as ERROR or error_deleting,
+// and when poll fails or the context is done.
+func WaitFor(ctx context.Context, target string, failed []string, poll Poll, opts *WaitOptions) error {
+	if opts == nil {
+		opts = &WaitOptions{}
+	}
+	interval, max := opts.Interval, opts.MaxInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	for {
+		status, progress, err := poll(ctx)
+		if err != nil {
+			return err
+		}
+		if opts.Progress != nil {
+			opts.Progress(status, progress)
+		}
+		if strings.EqualFold(status, target) {
+			return nil
+		}
+		if isFailedStatus(status, failed) {
+			return errors.New(fmt.Sprintf("Waiting for %s, got status: %s", target, status))
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if interval *= 2; interval > max {
+			interval = max
+		}
+	}
+}
+
+func isFailedStatus(status string, failed []string) bool {
+	if strings.HasPrefix(strings.ToUpper(status), "ERROR") {
+		return true
+	}
+	for _, f := range failed {
+		if strings.EqualFold(status, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// withContext returns a copy of the Access whose requests are
+// cancelled when the context is done.
+func (a Access) withContext(ctx context.Context) Access {
+	base := a.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	a.Client.Transport = contextTransport{ctx, base}
+	return a
+}
+
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// WaitForServer waits for the server to become ACTIVE and returns its
+// final details. A server which is deleted or shut off instead fails
+// the wait.
+func (a Access) WaitForServer(ctx context.Context, server_id string, opts *WaitOptions) (*ServerDetail, error) {
+	var s *ServerDetail
+	failed := []string{"DELETED", "SOFT_DELETED", "SHUTOFF"}
+	err := WaitFor(ctx, "ACTIVE", failed, func(ctx context.Context) (string, int, error) {
+		var err error
+		s, err = a.withContext(ctx).GetServer(server_id)
+		if err != nil {
+			return "", 0, err
+		}
+		return s.Status, s.Progress, nil
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WaitForImage waits for the image, such as one made by CreateImage,
+// to become ACTIVE.
+func (a Access) WaitForImage(ctx context.Context, image_id string, opts *WaitOptions) (*Image, error) {
+	var i *Image
+	failed := []string{"DELETED", "KILLED"}
+	err := WaitFor(ctx, "ACTIVE", failed, func(ctx context.Context) (string, int, error) {
+		var err error
+		i, err = a.withContext(ctx).ListImage(image_id)
+		if err != nil {
+			return "", 0, err
+		}
+		return i.I.Status, i.I.Progress, nil
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// WaitForVolume waits for the volume to become available.
+func (a Access) WaitForVolume(ctx context.Context, volume_id string, opts *WaitOptions) (*Volume, error) {
+	var v *Volume
+	failed := []string{"deleting"}
+	err := WaitFor(ctx, "available", failed, func(ctx context.Context) (string, int, error) {
+		var err error
+		v, err = a.withContext(ctx).GetVolume(volume_id)
+		if err != nil {
+			return "", 0, err
+		}
+		return v.Status, 0, nil
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// WaitForDBInstance waits for the database instance to be running.
+func (a Access) WaitForDBInstance(ctx context.Context, id string, opts *WaitOptions) (*InstDetails, error) {
+	var d *InstDetails
+	failed := []string{"deleted", "failed"}
+	err := WaitFor(ctx, "running", failed, func(ctx context.Context) (string, int, error) {
+		var err error
+		d, err = a.withContext(ctx).GetDBInstance(id)
+		if err != nil {
+			return "", 0, err
+		}
+		return d.Status, 0, nil
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
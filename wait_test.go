@@ -0,0 +1,129 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWaitFor(t *testing.T) {
+	statuses := []string{"BUILD", "BUILD", "ACTIVE"}
+	seen := []string{}
+	err := WaitFor(context.Background(), "active", nil, func(context.Context) (string, int, error) {
+		status := statuses[0]
+		statuses = statuses[1:]
+		return status, 50, nil
+	}, &WaitOptions{
+		Interval: time.Millisecond,
+		Progress: func(status string, progress int) {
+			seen = append(seen, status)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 3 || seen[2] != "ACTIVE" {
+		t.Errorf("Unexpected progress reports: %v", seen)
+	}
+}
+
+func TestWaitForFailsFast(t *testing.T) {
+	polls := 0
+	err := WaitFor(context.Background(), "available", nil, func(context.Context) (string, int, error) {
+		polls++
+		return "error_restoring", 0, nil
+	}, &WaitOptions{Interval: time.Hour})
+	if err == nil || polls != 1 {
+		t.Errorf("Expected a single failed poll, got %d polls and %v", polls, err)
+	}
+}
+
+func TestWaitForFailedStatus(t *testing.T) {
+	polls := 0
+	err := WaitFor(context.Background(), "ACTIVE", []string{"DELETED", "SHUTOFF"}, func(context.Context) (string, int, error) {
+		polls++
+		return "shutoff", 0, nil
+	}, &WaitOptions{Interval: time.Hour})
+	if err == nil || polls != 1 {
+		t.Errorf("Expected a single failed poll, got %d polls and %v", polls, err)
+	}
+}
+
+func TestWaitForDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := WaitFor(ctx, "ACTIVE", nil, func(context.Context) (string, int, error) {
+		return "BUILD", 0, nil
+	}, &WaitOptions{Interval: time.Millisecond, MaxInterval: 2 * time.Millisecond})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+func TestWaitForServerHungRequest(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := test_account.WaitForServer(ctx, "1", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to cancel the request, got %v", err)
+	}
+}
+
+func TestWaitForDBInstance(t *testing.T) {
+	polls := 0
+	httpTestsSetUp(func(w http.ResponseWriter, req *http.Request) {
+		polls++
+		status := "building"
+		if polls > 1 {
+			status = "running"
+		}
+		w.Write([]byte(`{"instance": {"id": "db-1", "status": "` + status + `"}}`))
+	})
+	d, err := test_account.WaitForDBInstance(context.Background(), "db-1", &WaitOptions{Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "db-1" || polls != 2 {
+		t.Errorf("Unexpected instance %+v after %d polls", d, polls)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2013, Aaron France
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+
+//     * Neither the name of Aaron France nor the names of its
+//       contributors may be used to endorse or promote products derived
+//       from this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package hpcloud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Container metadata read by the object store's staticweb middleware. */
+const (
+	webIndexHeader       = "X-Container-Meta-Web-Index"
+	webErrorHeader       = "X-Container-Meta-Web-Error"
+	webListingsHeader    = "X-Container-Meta-Web-Listings"
+	webListingsCSSHeader = "X-Container-Meta-Web-Listings-Css"
+)
+
+// WebsiteConfig describes how a container is served as a static
+// website.
+type WebsiteConfig struct {
+	// Index is the object served for directory requests, for
+	// example "index.html".
+	Index string
+	// Error is the suffix of the objects served for errors, with
+	// "error.html" a 404 serves "404error.html".
+	Error string
+	// Listings serves listings of directories with no index.
+	Listings bool
+	// ListingsCSS is the path of a stylesheet for the listings.
+	ListingsCSS string
+}
+
+// UpdateContainerMetadata sets the headers on a container, which is
+// how its X-Container-Meta- metadata and ACLs are changed.
+func (a Access) UpdateContainerMetadata(container string, header http.Header) error {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s", a.objectStoreURL(), escapeObjectName(container)), nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range header {
+		req.Header[key] = value
+	}
+	req.Header.Add("X-Auth-Token", a.AuthToken())
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return StatusError{http.StatusNoContent, resp.StatusCode}
+	}
+	return nil
+}
+
+// SetContainerWebsite configures the container as a static website.
+// Any empty fields in the config are removed from the container.
+func (a Access) SetContainerWebsite(container string, cfg WebsiteConfig) error {
+	h := http.Header{}
+	set := func(key, value string) {
+		if value == "" {
+			h.Set("X-Remove-"+strings.TrimPrefix(key, "X-"), "x")
+		} else {
+			h.Set(key, value)
+		}
+	}
+	set(webIndexHeader, cfg.Index)
+	set(webErrorHeader, cfg.Error)
+	set(webListingsHeader, strconv.FormatBool(cfg.Listings))
+	set(webListingsCSSHeader, cfg.ListingsCSS)
+	return a.UpdateContainerMetadata(container, h)
+}
+
+// GetContainerWebsite returns the static website configuration of the
+// container.
+func (a Access) GetContainerWebsite(container string) (*WebsiteConfig, error) {
+	h, err := a.ObjectStoreHead(container)
+	if err != nil {
+		return nil, err
+	}
+	listings, _ := strconv.ParseBool(h.Get(webListingsHeader))
+	return &WebsiteConfig{
+		Index:       h.Get(webIndexHeader),
+		Error:       h.Get(webErrorHeader),
+		Listings:    listings,
+		ListingsCSS: h.Get(webListingsCSSHeader),
+	}, nil
+}
+
+// PublishWebsite uploads every file below dir into the container,
+// keeping their paths relative to dir as the object names, configures
+// the container as a website and enables it on the CDN. It returns the
+// container's CDN URI.
+func (a Access) PublishWebsite(dir, container string, cfg WebsiteConfig) (string, error) {
+	if err := a.CreateContainer(container); err != nil {
+		return "", err
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return a.ObjectStoreUpload(path, container, &UploadOptions{Name: filepath.ToSlash(rel)})
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := a.SetContainerWebsite(container, cfg); err != nil {
+		return "", err
+	}
+	c, err := a.GetCDNContainer(container)
+	se, ok := err.(StatusError)
+	enabled := true
+	switch {
+	case ok && se.StatusCode == http.StatusNotFound:
+		err = a.CreateCDNContainer(container, CDNSettings{Enabled: &enabled})
+	case err != nil:
+		return "", err
+	case !c.CDNEnabled:
+		err = a.UpdateCDNContainer(container, CDNSettings{Enabled: &enabled})
+	}
+	if err != nil {
+		return "", err
+	}
+	c, err = a.GetCDNContainer(container)
+	if err != nil {
+		return "", err
+	}
+	if c.CDNUri == "" {
+		return "", errors.New("The CDN did not return a URI for the container.")
+	}
+	return c.CDNUri, nil
+}